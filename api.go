@@ -0,0 +1,175 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// OpenAPI document of the HTTP API.
+//
+//go:embed openapi.json
+var openAPI []byte
+
+// apiProc is a proc in the responses of the HTTP API.
+type apiProc struct {
+	Name    string          `json:"name"`
+	Status  string          `json:"status"`
+	Running bool            `json:"running"`
+	Pid     int             `json:"pid,omitempty"`
+	Healthy *bool           `json:"healthy,omitempty"`
+	Ports   map[string]uint `json:"ports,omitempty"`
+}
+
+func newAPIProc(proc *procInfo) apiProc {
+	p := apiProc{Name: proc.name, Status: proc.status()}
+	proc.mu.Lock()
+	if proc.cmd != nil {
+		p.Running = true
+		if proc.cmd.Process != nil {
+			p.Pid = proc.cmd.Process.Pid
+		}
+	}
+	proc.mu.Unlock()
+	if proc.healthCheck != nil {
+		healthy := proc.healthy.Load()
+		p.Healthy = &healthy
+	}
+	for _, port := range proc.ports() {
+		if p.Ports == nil {
+			p.Ports = map[string]uint{}
+		}
+		p.Ports[port.env] = *port.port
+	}
+	return p
+}
+
+// apiHandler returns the handler of the HTTP API. Changes to procs are sent
+// to the supervisor loop like those of RPC calls.
+func (r *Goreman) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPI)
+	})
+	mux.HandleFunc("GET /procs", r.apiList)
+	mux.HandleFunc("GET /procs/{name}", r.apiProc(nil))
+	mux.HandleFunc("GET /procs/{name}/logs", r.apiLogs)
+	for _, action := range []string{"start", "stop", "restart"} {
+		mux.HandleFunc("POST /procs/{name}/"+action, r.apiProc(func(req *http.Request, name string) error {
+			return r.rpcExec(action, []string{name})
+		}))
+	}
+	mux.HandleFunc("POST /procs/{name}/signal", r.apiProc(func(req *http.Request, name string) error {
+		sig := req.URL.Query().Get("signal")
+		if sig == "" {
+			var body struct {
+				Signal string `json:"signal"`
+			}
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				return apiError{http.StatusBadRequest, "signal: " + err.Error()}
+			}
+			sig = body.Signal
+		}
+		if _, err := parseSignal(sig); err != nil {
+			return apiError{http.StatusBadRequest, err.Error()}
+		}
+		return r.rpcExec("signal", []string{sig, name})
+	}))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/openapi.json" {
+			token, _ := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if err := r.authorize(token); err != nil {
+				writeAPIError(w, apiError{http.StatusUnauthorized, err.Error()})
+				return
+			}
+		}
+		mux.ServeHTTP(w, req)
+	})
+}
+
+func (r *Goreman) apiList(w http.ResponseWriter, req *http.Request) {
+	mu.Lock()
+	ps := make([]*procInfo, len(procs))
+	copy(ps, procs)
+	mu.Unlock()
+	list := []apiProc{}
+	for _, proc := range ps {
+		list = append(list, newAPIProc(proc))
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// apiProc returns the handler of a request for the proc in the path, which
+// runs action, if not nil, and responds with the proc.
+func (r *Goreman) apiProc(action func(req *http.Request, name string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		name := req.PathValue("name")
+		proc := findProc(name)
+		if proc == nil {
+			writeAPIError(w, apiError{http.StatusNotFound, "unknown proc: " + name})
+			return
+		}
+		if action != nil {
+			if err := action(req, name); err != nil {
+				writeAPIError(w, err)
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, newAPIProc(proc))
+	}
+}
+
+func (r *Goreman) apiLogs(w http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("name")
+	proc := findProc(name)
+	if proc == nil {
+		writeAPIError(w, apiError{http.StatusNotFound, "unknown proc: " + name})
+		return
+	}
+	lines := proc.recentLog()
+	if s := req.URL.Query().Get("lines"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeAPIError(w, apiError{http.StatusBadRequest, "lines: not a number: " + s})
+			return
+		}
+		if n < len(lines) {
+			lines = lines[len(lines)-n:]
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string][]string{"lines": lines})
+}
+
+// apiError is an error with the HTTP status of its response.
+type apiError struct {
+	code int
+	msg  string
+}
+
+func (e apiError) Error() string { return e.msg }
+
+// writeAPIError responds with err as {"error": "..."}.
+func writeAPIError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	var apiErr apiError
+	switch {
+	case errors.As(err, &apiErr):
+		code = apiErr.code
+	case errors.Is(err, errNotRunning):
+		code = http.StatusConflict
+	}
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
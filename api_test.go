@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPI(t *testing.T) {
+	dir := t.TempDir()
+	procfile := filepath.Join(dir, "Procfile")
+	if err := os.WriteFile(procfile, []byte("web1: sleep 10\nweb2: sleep 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOREMAN_RPC_TOKEN", "secret")
+	cfg := &config{
+		Procfile: procfile,
+		Listen:   []string{"unix:" + filepath.Join(dir, socketFile)},
+	}
+	sc := make(chan os.Signal, 1)
+	done := make(chan struct{}, 1)
+	go func() {
+		start(context.TODO(), sc, cfg)
+		done <- struct{}{}
+	}()
+	defer func() {
+		sc <- os.Interrupt
+		<-done
+	}()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", filepath.Join(dir, socketFile))
+		},
+	}}
+	call := func(method, path, token, body string, v any) int {
+		t.Helper()
+		req, err := http.NewRequest(method, "http://goreman"+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if v != nil {
+			if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+				t.Fatalf("%s %s: %v", method, path, err)
+			}
+		}
+		return res.StatusCode
+	}
+	for i := 0; ; i++ {
+		if _, err := os.Stat(filepath.Join(dir, socketFile)); err != nil {
+			if i > 100 {
+				t.Fatal(err)
+			}
+			time.Sleep(20 * time.Millisecond)
+			continue
+		}
+		var list []apiProc
+		if call("GET", "/procs", "secret", "", &list) == http.StatusOK && len(list) == 2 && list[0].Running && list[1].Running {
+			break
+		}
+		if i > 100 {
+			t.Fatalf("procs did not start: %+v", list)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	var doc map[string]any
+	if code := call("GET", "/openapi.json", "", "", &doc); code != http.StatusOK || doc["openapi"] == nil {
+		t.Errorf("openapi.json: got %d %v", code, doc)
+	}
+	var apiErr map[string]string
+	for _, token := range []string{"", "wrong"} {
+		if code := call("GET", "/procs", token, "", &apiErr); code != http.StatusUnauthorized {
+			t.Errorf("token %q: want 401, got %d %v", token, code, apiErr)
+		}
+	}
+	if code := call("GET", "/procs/nope", "secret", "", &apiErr); code != http.StatusNotFound {
+		t.Errorf("unknown proc: want 404, got %d", code)
+	}
+
+	var p apiProc
+	if code := call("POST", "/procs/web1/stop", "secret", "", &p); code != http.StatusOK || p.Running || p.Status != "stopped" {
+		t.Errorf("stop: got %d %+v", code, p)
+	}
+	if code := call("POST", "/procs/web1/signal?signal=HUP", "secret", "", &apiErr); code != http.StatusConflict {
+		t.Errorf("signal to a stopped proc: want 409, got %d %v", code, apiErr)
+	}
+	if code := call("POST", "/procs/web1/start", "secret", "", &p); code != http.StatusOK || !p.Running || p.Pid == 0 {
+		t.Errorf("start: got %d %+v", code, p)
+	}
+	if code := call("POST", "/procs/web2/signal", "secret", `{"signal": "BOGUS"}`, &apiErr); code != http.StatusBadRequest {
+		t.Errorf("unknown signal: want 400, got %d %v", code, apiErr)
+	}
+	if code := call("POST", "/procs/web2/signal", "secret", `{"signal": "SIGCONT"}`, &p); code != http.StatusOK || !p.Running {
+		t.Errorf("signal: got %d %+v", code, p)
+	}
+
+	var logs struct{ Lines []string }
+	if code := call("GET", "/procs/web1/logs?lines=2", "secret", "", &logs); code != http.StatusOK || len(logs.Lines) != 2 || !strings.HasPrefix(logs.Lines[1], "Starting web1") {
+		t.Errorf("logs: got %d %q", code, logs.Lines)
+	}
+
+	// net/rpc still works on the same socket.
+	if err := run(cfg, "status", nil); err != nil {
+		t.Error(err)
+	}
+
+	// and so does JSON-RPC 2.0, as plain JSON lines.
+	conn, err := net.Dial("unix", filepath.Join(dir, socketFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	dec := json.NewDecoder(conn)
+	for _, tt := range []struct {
+		req, res string
+	}{
+		// leading whitespace does not hide the JSON object.
+		{"\n  " + `{"jsonrpc": "2.0", "method": "Goreman.List", "params": [{"token": "secret"}], "id": 1}`, `{"jsonrpc":"2.0","id":1,"result":"web1\nweb2\n"}`},
+		{`{"jsonrpc": "2.0", "method": "Procs", "params": {"token": "secret", "args": ["web2"]}, "id": "a"}`, `{"jsonrpc":"2.0","id":"a","result":[{"name":"web2","status":"running","running":true,"pid":0,"ports":{"PORT":100}}]}`},
+		// a notification gets no response.
+		{`{"jsonrpc": "2.0", "method": "Signal", "params": {"token": "secret", "args": ["CONT", "web2"]}}`, ""},
+		{`{"jsonrpc": "2.0", "method": "Signal", "params": {"token": "secret", "args": ["CONT", "web2"]}, "id": 2}`, `{"jsonrpc":"2.0","id":2,"result":""}`},
+		{`{"jsonrpc": "2.0", "method": "Status", "params": {"token": "wrong"}, "id": 3}`, `{"jsonrpc":"2.0","id":3,"error":{"code":-32000,"message":"invalid RPC token"}}`},
+		{`{"jsonrpc": "2.0", "method": "Nope", "id": 4}`, `{"jsonrpc":"2.0","id":4,"error":{"code":-32601,"message":"method not found: Goreman.Nope"}}`},
+		{`{"jsonrpc": "2.0", "method": "Status", "params": {"token": 1}, "id": 5}`, `{"jsonrpc":"2.0","id":5,"error":{"code":-32602,"message":"invalid params"}}`},
+		{`{"method": "Status", "params": [{"token": "secret"}], "id": 6}`, `{"jsonrpc":"2.0","id":6,"error":{"code":-32600,"message":"invalid request: jsonrpc must be \"2.0\""}}`},
+		{`{"jsonrpc": "2.0", "method": "Status", "params": "secret", "id": 7}`, `{"jsonrpc":"2.0","id":7,"error":{"code":-32600,"message":"invalid request: params must be an object or an array"}}`},
+		{`{"jsonrpc": }`, `{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"parse error: invalid character '}' looking for beginning of value"}}`},
+	} {
+		if _, err := conn.Write([]byte(tt.req + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		if tt.res == "" {
+			continue
+		}
+		var res map[string]any
+		if err := dec.Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		if result, ok := res["result"].([]any); ok && len(result) == 1 {
+			// the pid changes from run to run.
+			result[0].(map[string]any)["pid"] = 0.0
+		}
+		if e, ok := res["error"].(map[string]any); ok {
+			// the details come from encoding/json.
+			e["message"], _, _ = strings.Cut(e["message"].(string), ": json: ")
+		}
+		var want map[string]any
+		if err := json.Unmarshal([]byte(tt.res), &want); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(res, want) {
+			t.Errorf("%s:\nwant %v\ngot  %v", tt.req, want, res)
+		}
+	}
+}
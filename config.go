@@ -0,0 +1,574 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// name of the config file.
+const configFile = ".goreman"
+
+// procConfig is the configuration of a single proc in the procs section of
+// .goreman. Unset fields keep the values from the Procfile and flags.
+type procConfig struct {
+	// Working directory of the proc.
+	Cwd string `yaml:"cwd,omitempty"`
+	// Environment variables of the proc.
+	Env map[string]string `yaml:"env,omitempty"`
+	// Environment files of the proc, loaded before Env.
+	EnvFile []string `yaml:"env_file,omitempty"`
+	// Value of PORT, overriding the port assigned from the base port.
+	Port uint `yaml:"port,omitempty"`
+	// Additional ports, set as PORT_<NAME>. 0 assigns the next port after
+	// the one from the base port.
+	Ports map[string]uint `yaml:"ports,omitempty"`
+	// Signal sent by stop and restart, e.g. SIGTERM. Defaults to SIGINT.
+	StopSignal string `yaml:"stop_signal,omitempty"`
+	// How long to wait before killing the proc. Defaults to 10s.
+	StopTimeout time.Duration `yaml:"stop_timeout,omitempty"`
+	// When to restart the proc after it exits: "no" (default),
+	// "on-failure" or "always".
+	Restart string `yaml:"restart,omitempty"`
+	// Color of the proc name in the log, e.g. "red".
+	Color string `yaml:"color,omitempty"`
+	// Procs which must be ready before this proc is started.
+	DependsOn []string `yaml:"depends_on,omitempty"`
+	// How to check that the proc is ready.
+	HealthCheck *healthCheck `yaml:"health_check,omitempty"`
+	// If false, the proc is not started unless named on the command line
+	// or started through goreman run start. Defaults to true.
+	Autostart *bool `yaml:"autostart,omitempty"`
+}
+
+// restart policies.
+const (
+	restartNo        = "no"
+	restartOnFailure = "on-failure"
+	restartAlways    = "always"
+)
+
+var colorNames = []string{"green", "cyan", "magenta", "yellow", "blue", "red"}
+
+// apply merges pc onto proc, and returns every invalid setting. cfg is used
+// to locate errors.
+func (pc *procConfig) apply(cfg *config, proc *procInfo) error {
+	var errs []error
+	key := "procs." + proc.name + "."
+	lookup := lookupChain(lookupMap(cfg.env), os.LookupEnv)
+	if pc.Cwd != "" {
+		dir, err := interpolate(pc.Cwd, interpPlain, lookup)
+		if err != nil {
+			errs = append(errs, cfg.errorf(key+"cwd", "%s: %v", proc.name, err))
+		}
+		proc.dir = dir
+	}
+	if len(pc.EnvFile) > 0 || len(pc.Env) > 0 {
+		env := map[string]string{}
+		for i, file := range pc.EnvFile {
+			err := readEnvFile(file, env, lookup)
+			var pathErr *fs.PathError
+			if errors.As(err, &pathErr) {
+				errs = append(errs, cfg.errorf(key+"env_file."+strconv.Itoa(i), "%s: %v", proc.name, err))
+			} else if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		envLookup := lookupChain(lookup, lookupMap(env))
+		for _, k := range sortedKeys(pc.Env) {
+			v, err := interpolate(pc.Env[k], interpPlain, envLookup)
+			if kind, arg, ok := secretRef(v); ok && err == nil {
+				v, err = cfg.secret(kind, arg)
+				if proc.secrets == nil {
+					proc.secrets = map[string]bool{}
+				}
+				proc.secrets[k] = true
+			}
+			if err != nil {
+				errs = append(errs, cfg.errorf(key+"env."+k, "%s: %s: %v", proc.name, k, err))
+			}
+			env[k] = v
+		}
+		proc.env = env
+	}
+	next := proc.port
+	for _, name := range sortedKeys(pc.Ports) {
+		p := namedPort{name: name, port: pc.Ports[name], fixed: true}
+		if p.port == 0 {
+			next++
+			p.port, p.fixed = next, false
+		}
+		proc.namedPorts = append(proc.namedPorts, p)
+	}
+	if pc.Port != 0 {
+		proc.setPort = true
+		proc.fixedPort = true
+		proc.port = pc.Port
+	}
+	if pc.StopSignal != "" {
+		sig, err := parseSignal(pc.StopSignal)
+		if err != nil {
+			errs = append(errs, cfg.errorf(key+"stop_signal", "%s: %v", proc.name, err))
+		}
+		proc.stopSignal = sig
+	}
+	if pc.StopTimeout != 0 {
+		proc.stopTimeout = pc.StopTimeout
+	}
+	switch pc.Restart {
+	case "":
+	case restartNo, restartOnFailure, restartAlways:
+		proc.restart = pc.Restart
+	default:
+		errs = append(errs, cfg.errorf(key+"restart", "%s: unknown restart policy: %s", proc.name, pc.Restart))
+	}
+	if pc.Color != "" {
+		if i := indexOf(colorNames, strings.ToLower(pc.Color)); i >= 0 {
+			proc.colorIndex = i
+		} else {
+			errs = append(errs, cfg.errorf(key+"color", "%s: unknown color: %s", proc.name, pc.Color))
+		}
+	}
+	proc.dependsOn = pc.DependsOn
+	proc.healthCheck = pc.HealthCheck
+	if pc.Autostart != nil {
+		proc.autostart = *pc.Autostart
+	}
+	return errors.Join(errs...)
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// loadConfigFile decodes the config file at path onto cfg. Unknown keys and
+// values of the wrong type are reported as "path:line: message", after the
+// rest of the file has been decoded.
+func loadConfigFile(path string, cfg *config) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	cfg.file = path
+	var node yaml.Node
+	if err := yaml.Unmarshal(b, &node); err != nil {
+		return cfg.yamlError(err)
+	}
+	cfg.lines = map[string]int{}
+	recordLines(&node, "", cfg.lines)
+
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && err != io.EOF {
+		return cfg.yamlError(err)
+	}
+	return nil
+}
+
+// recordLines records the line of every key below node in lines, keyed by
+// the dotted path of the key, e.g. "procs.web.port".
+func recordLines(node *yaml.Node, path string, lines map[string]int) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, n := range node.Content {
+			recordLines(n, path, lines)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if path != "" {
+				key = path + "." + key
+			}
+			lines[key] = node.Content[i].Line
+			recordLines(node.Content[i+1], key, lines)
+		}
+	case yaml.SequenceNode:
+		for i, n := range node.Content {
+			key := path + "." + strconv.Itoa(i)
+			lines[key] = n.Line
+			recordLines(n, key, lines)
+		}
+	}
+}
+
+var yamlLineRe = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// yamlError rewrites the "line N: message" errors of the yaml package as
+// "file:N: message".
+func (cfg *config) yamlError(err error) error {
+	var msgs []string
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		msgs = typeErr.Errors
+	} else {
+		msgs = []string{err.Error()}
+	}
+	errs := make([]error, len(msgs))
+	for i, msg := range msgs {
+		if m := yamlLineRe.FindStringSubmatch(msg); m != nil {
+			errs[i] = fmt.Errorf("%s:%s: %s", cfg.file, m[1], m[2])
+		} else {
+			errs[i] = fmt.Errorf("%s: %s", cfg.file, msg)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// errorf returns an error prefixed with the location of key in the config
+// file, if known.
+func (cfg *config) errorf(key, format string, a ...any) error {
+	msg := fmt.Sprintf(format, a...)
+	for ; key != ""; key = key[:max(strings.LastIndex(key, "."), 0)] {
+		if line, ok := cfg.lines[key]; ok {
+			return fmt.Errorf("%s:%d: %s", cfg.file, line, msg)
+		}
+	}
+	return errors.New(msg)
+}
+
+// validateConfig checks the config against the loaded procs, and returns
+// every problem found.
+func validateConfig(cfg *config) error {
+	var errs []error
+
+	mu.Lock()
+	ps := make([]*procInfo, len(procs))
+	copy(ps, procs)
+	mu.Unlock()
+	byName := map[string]*procInfo{}
+	for _, proc := range ps {
+		byName[proc.name] = proc
+	}
+
+	for _, name := range sortedKeys(cfg.Procs) {
+		if byName[name] == nil {
+			errs = append(errs, cfg.errorf("procs."+name, "unknown proc in config: %s", name))
+		}
+	}
+	for i, name := range cfg.selected {
+		if byName[name] == nil {
+			errs = append(errs, cfg.errorf("profiles."+cfg.Profile+".select."+strconv.Itoa(i), "unknown proc in profile %s: %s", cfg.Profile, name))
+		}
+	}
+
+	ports := map[uint]string{}
+	for _, proc := range ps {
+		key := "procs." + proc.name + "."
+		for i, dep := range proc.dependsOn {
+			depKey := key + "depends_on." + strconv.Itoa(i)
+			switch d := byName[dep]; {
+			case d == nil:
+				errs = append(errs, cfg.errorf(depKey, "%s depends on unknown proc %s", proc.name, dep))
+			case d == proc:
+				errs = append(errs, cfg.errorf(depKey, "%s depends on itself", proc.name))
+			case proc.autostart && !d.autostart:
+				errs = append(errs, cfg.errorf(depKey, "%s depends on %s, which is not started automatically", proc.name, dep))
+			}
+		}
+		// report each cycle once, at its first proc by name.
+		if cycle := dependencyCycle(proc, byName, nil); cycle != nil && cycle[0] == proc.name && proc.name == slices.Min(cycle) {
+			errs = append(errs, cfg.errorf(key+"depends_on", "dependency cycle: %s", strings.Join(cycle, " -> ")))
+		}
+
+		if hc := proc.healthCheck; hc != nil {
+			switch {
+			case hc.HTTP == "" && hc.Command == "":
+				errs = append(errs, cfg.errorf(key+"health_check", "%s: health_check needs http or command", proc.name))
+			case hc.HTTP != "" && !strings.Contains(hc.HTTP, "://") && !proc.setPort:
+				errs = append(errs, cfg.errorf(key+"health_check.http", "%s: http health_check needs a port", proc.name))
+			}
+		}
+
+		for _, p := range proc.ports() {
+			portKey := key + "port"
+			if p.name != "" {
+				portKey = key + "ports." + p.name
+			}
+			switch other, ok := ports[*p.port]; {
+			case *p.port == 0:
+			case ok:
+				errs = append(errs, cfg.errorf(portKey, "%s: port %d is already used by %s", proc.name, *p.port, other))
+			case *p.port == cfg.Port:
+				errs = append(errs, cfg.errorf(portKey, "%s: port %d is the RPC port", proc.name, *p.port))
+				ports[*p.port] = proc.name
+			default:
+				ports[*p.port] = proc.name
+			}
+		}
+	}
+
+	for _, prefix := range sortedKeys(cfg.Proxy.Paths) {
+		name := cfg.Proxy.Paths[prefix]
+		if p := byName[name]; p == nil || !p.setPort {
+			errs = append(errs, cfg.errorf("proxy.paths."+prefix, "proxy: %s is not a proc with a port", name))
+		}
+	}
+	if name := cfg.Proxy.Default; name != "" {
+		if p := byName[name]; p == nil || !p.setPort {
+			errs = append(errs, cfg.errorf("proxy.default", "proxy: %s is not a proc with a port", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// dependencyCycle returns the names along a dependency cycle reachable from
+// proc, ending with the repeated name, or nil if there is none.
+func dependencyCycle(proc *procInfo, byName map[string]*procInfo, path []string) []string {
+	for i, name := range path {
+		if name == proc.name {
+			return append(path[i:], proc.name)
+		}
+	}
+	path = append(path, proc.name)
+	for _, dep := range proc.dependsOn {
+		if d := byName[dep]; d != nil && d != proc {
+			if cycle := dependencyCycle(d, byName, path); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// loadProcs reads the Procfile and validates the config against it. All
+// problems found in the config are returned together.
+func loadProcs(cfg *config) error {
+	if err := applyProfile(cfg); err != nil {
+		return errors.Join(cfg.loadErr, err)
+	}
+	envErr := readEnv(cfg)
+	err := readProcfile(cfg)
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) || errors.Is(err, errNoEntry) {
+		return errors.Join(cfg.loadErr, envErr, err)
+	}
+	err = errors.Join(cfg.loadErr, envErr, err, validateConfig(cfg))
+	if err != nil {
+		return err
+	}
+	if len(cfg.selected) > 0 {
+		return selectProcs(cfg.selected)
+	}
+	return nil
+}
+
+// setting is a global setting which can be given as a flag, an environment
+// variable or a key in the config file, in decreasing order of precedence.
+type setting struct {
+	key  string // key in the config file
+	flag string // name of the flag
+	env  string // name of the environment variable
+	also string // another key in the config file set along with key
+	set  func(cfg *config, s string) error
+}
+
+// origin records where the setting came from.
+func (s setting) origin(cfg *config, origin string) {
+	cfg.origins[s.key] = origin
+	if s.also != "" {
+		cfg.origins[s.also] = origin
+	}
+}
+
+func parseUint(s string) (uint, error) {
+	n, err := strconv.ParseUint(s, 10, 16)
+	return uint(n), err
+}
+
+var settings = []setting{
+	{"procfile", "f", "GOREMAN_PROCFILE", "overlays", func(cfg *config, s string) error {
+		// "Procfile,Procfile.dev" is a Procfile and its overlays.
+		files := strings.FieldsFunc(s, func(char rune) bool { return char == ',' })
+		if len(files) == 0 {
+			return errors.New("no Procfile")
+		}
+		cfg.Procfile, cfg.Overlays = files[0], files[1:]
+		return nil
+	}},
+	{"port", "p", "GOREMAN_RPC_PORT", "", func(cfg *config, s string) (err error) {
+		cfg.Port, err = parseUint(s)
+		return err
+	}},
+	{"socket", "rpc-socket", "GOREMAN_RPC_SOCKET", "", func(cfg *config, s string) error {
+		cfg.Socket = s
+		return nil
+	}},
+	{"listen", "rpc-listen", "GOREMAN_RPC_LISTEN", "", func(cfg *config, s string) error {
+		cfg.Listen = strings.FieldsFunc(s, func(char rune) bool { return char == ',' })
+		return nil
+	}},
+	{"tls", "rpc-tls", "GOREMAN_RPC_TLS", "", func(cfg *config, s string) (err error) {
+		cfg.TLS, err = strconv.ParseBool(s)
+		return err
+	}},
+	{"basedir", "basedir", "GOREMAN_BASEDIR", "", func(cfg *config, s string) error {
+		cfg.BaseDir = s
+		return nil
+	}},
+	{"baseport", "b", "GOREMAN_BASEPORT", "", func(cfg *config, s string) (err error) {
+		cfg.BasePort, err = parseUint(s)
+		return err
+	}},
+	{"envfiles", "env", "GOREMAN_ENV_FILES", "", func(cfg *config, s string) error {
+		cfg.EnvFiles = strings.FieldsFunc(s, func(char rune) bool { return char == ',' })
+		return nil
+	}},
+	{"exit_on_error", "exit-on-error", "GOREMAN_EXIT_ON_ERROR", "", func(cfg *config, s string) (err error) {
+		cfg.ExitOnError, err = strconv.ParseBool(s)
+		return err
+	}},
+	{"formation", "m", "GOREMAN_FORMATION", "", func(cfg *config, s string) (err error) {
+		cfg.Formation, err = parseFormation(s)
+		return err
+	}},
+	{"profile", "profile", "GOREMAN_PROFILE", "", func(cfg *config, s string) error {
+		cfg.Profile = s
+		return nil
+	}},
+}
+
+// loadConfig builds the configuration from the defaults of the flags in fs,
+// the config file, the environment and the flags given on the command line,
+// later ones overriding earlier ones. Errors in the config file are kept in
+// cfg.loadErr, so that commands which do not need it still work.
+func loadConfig(fs *flag.FlagSet, lookupEnv func(string) (string, bool)) (*config, error) {
+	cfg := &config{origins: map[string]string{}}
+	for _, s := range settings {
+		if err := s.set(cfg, fs.Lookup(s.flag).DefValue); err != nil {
+			return nil, err
+		}
+		s.origin(cfg, "default")
+	}
+
+	path, explicit := configFile, false
+	if s, ok := lookupEnv("GOREMAN_CONFIG"); ok {
+		path, explicit = s, true
+	}
+	if f := fs.Lookup("config"); f != nil && f.Value.String() != "" {
+		path, explicit = f.Value.String(), true
+	}
+	err := loadConfigFile(path, cfg)
+	if os.IsNotExist(err) && !explicit {
+		err = nil
+	}
+	cfg.loadErr = err
+	for key, line := range cfg.lines {
+		cfg.origins[key] = fmt.Sprintf("%s:%d", path, line)
+	}
+
+	for _, s := range settings {
+		if v, ok := lookupEnv(s.env); ok {
+			if err := s.set(cfg, v); err != nil {
+				return nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+			s.origin(cfg, "$"+s.env)
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag != f.Name {
+				continue
+			}
+			if err := s.set(cfg, f.Value.String()); err != nil {
+				flagErr = errors.Join(flagErr, fmt.Errorf("-%s: %w", f.Name, err))
+			}
+			s.origin(cfg, "-"+f.Name)
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+	return cfg, nil
+}
+
+// command: config. show the effective configuration and where each value
+// came from.
+func showConfig(cfg *config) error {
+	if err := applyProfile(cfg); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	if err := printSettings(w, reflect.ValueOf(cfg).Elem(), "", cfg.origins); err != nil {
+		return err
+	}
+	w.Flush()
+	return cfg.loadErr
+}
+
+// printSettings prints every setting in the struct v and the sections in it,
+// including empty ones which the config file may omit.
+func printSettings(w io.Writer, v reflect.Value, path string, origins map[string]string) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if !field.IsExported() || key == "-" {
+			continue
+		}
+		if path != "" {
+			key = path + "." + key
+		}
+		var err error
+		if field.Type.Kind() == reflect.Struct {
+			err = printSettings(w, v.Field(i), key, origins)
+		} else {
+			var node yaml.Node
+			if err = node.Encode(v.Field(i).Interface()); err == nil {
+				err = printNode(w, &node, key, path, origins)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printNode prints every value below node as "key: value # origin", with
+// the dotted path of the value as key. section is the path of the section
+// of the setting.
+func printNode(w io.Writer, node *yaml.Node, path, section string, origins map[string]string) error {
+	if node.Kind == yaml.MappingNode && len(node.Content) > 0 {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if err := printNode(w, node.Content[i+1], path+"."+node.Content[i].Value, section, origins); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	node.Style = yaml.FlowStyle
+	b, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	// values inside a setting given as a whole, like -m for formation,
+	// come from the setting.
+	origin := "default"
+	for key := path; key != section; key = key[:max(strings.LastIndex(key, "."), 0)] {
+		if o, ok := origins[key]; ok {
+			origin = o
+			break
+		}
+	}
+	fmt.Fprintf(w, "%s: %s\t# %s\n", path, strings.TrimSpace(string(b)), origin)
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".goreman")
+	err := os.WriteFile(path, []byte(`port: 9000
+exit_on_eror: true
+baseport: abc
+procs:
+  web:
+    port: 6000
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg config
+	err = loadConfigFile(path, &cfg)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{
+		path + ":2: field exit_on_eror not found",
+		path + ":3: cannot unmarshal",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not contain %q:\n%v", want, err)
+		}
+	}
+	// the valid keys are still decoded.
+	if cfg.Port != 9000 || cfg.Procs["web"].Port != 6000 {
+		t.Errorf("valid keys were not decoded: %+v", cfg)
+	}
+	if line := cfg.lines["procs.web.port"]; line != 6 {
+		t.Errorf("procs.web.port: want line 6, got %d", line)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "Procfile"), []byte("web: ./web\nworker: ./worker\nclock: ./clock\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, ".goreman")
+	err = os.WriteFile(path, []byte(`port: 8555
+procs:
+  web:
+    port: 8555
+    depends_on: [db, worker]
+  worker:
+    port: 8555
+    depends_on: [web]
+  clock:
+    restart: sometimes
+    health_check:
+      interval: 1s
+    ports:
+      admin: 8555
+  ghost:
+    cwd: /
+proxy:
+  paths:
+    /api: api
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config{Procfile: filepath.Join(dir, "Procfile")}
+	if err := loadConfigFile(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+	err = loadProcs(cfg)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{
+		path + ":10: clock: unknown restart policy: sometimes",
+		path + ":15: unknown proc in config: ghost",
+		path + ":5: web depends on unknown proc db",
+		path + ":5: dependency cycle: web -> worker -> web",
+		path + ":11: clock: health_check needs http or command",
+		path + ":4: web: port 8555 is the RPC port",
+		path + ":7: worker: port 8555 is already used by web",
+		path + ":14: clock: port 8555 is already used by web",
+		path + ":19: proxy: api is not a proc with a port",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not contain %q:\n%v", want, err)
+		}
+	}
+	if n := strings.Count(err.Error(), "dependency cycle"); n != 1 {
+		t.Errorf("cycle reported %d times:\n%v", n, err)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "goreman.yml")
+	err := os.WriteFile(path, []byte("procfile: Procfile.dev\nport: 9000\nbaseport: 7000\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a flag set with the same flags as the command line.
+	fs := flag.NewFlagSet("goreman", flag.ContinueOnError)
+	flag.CommandLine.VisitAll(func(f *flag.Flag) {
+		fs.String(f.Name, f.DefValue, f.Usage)
+	})
+	if err := fs.Parse([]string{"-config", path, "-b", "8000", "start"}); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"GOREMAN_RPC_PORT":  "9100",
+		"GOREMAN_BASEPORT":  "7100",
+		"GOREMAN_FORMATION": "web=2",
+	}
+	cfg, err := loadConfig(fs, func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.loadErr != nil {
+		t.Fatal(cfg.loadErr)
+	}
+	for _, tt := range []struct {
+		key    string
+		got    any
+		want   any
+		origin string
+	}{
+		{"procfile", cfg.Procfile, "Procfile.dev", path + ":1"},
+		{"port", cfg.Port, uint(9100), "$GOREMAN_RPC_PORT"},
+		{"baseport", cfg.BasePort, uint(8000), "-b"},
+		{"formation", cfg.instances("web"), 2, "$GOREMAN_FORMATION"},
+		{"exit_on_error", cfg.ExitOnError, false, "default"},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.key, tt.got, tt.want)
+		}
+		if origin := cfg.origins[tt.key]; origin != tt.origin {
+			t.Errorf("%s: got origin %q, want %q", tt.key, origin, tt.origin)
+		}
+	}
+}
+
+func TestProfile(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "Procfile"), []byte("web: ./web\nworker: ./worker\nclock: ./clock\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, ".goreman")
+	err = os.WriteFile(path, []byte(`procs:
+  web:
+    env: {A: "1"}
+profiles:
+  ci:
+    select: [web, worker]
+    envfiles: [.env.ci]
+    formation: {worker: 2}
+    procs:
+      web:
+        env: {B: "2"}
+        restart: always
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config{Procfile: filepath.Join(dir, "Procfile"), EnvFiles: []string{".env"}, Profile: "ci"}
+	if err := loadConfigFile(path, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadProcs(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(cfg.EnvFiles, ","); got != ".env,.env.ci" {
+		t.Errorf("envfiles: got %s", got)
+	}
+	if n := cfg.instances("worker"); n != 2 {
+		t.Errorf("worker: want 2 instances, got %d", n)
+	}
+	if findProc("clock") != nil {
+		t.Error("clock is not selected by the profile")
+	}
+	web := findProc("web")
+	if web == nil {
+		t.Fatal("web is selected by the profile")
+	}
+	if web.env["A"] != "1" || web.env["B"] != "2" || web.restart != restartAlways {
+		t.Errorf("profile settings were not merged: env=%v restart=%s", web.env, web.restart)
+	}
+
+	cfg.Profile, cfg.profileApplied = "nope", false
+	if err := loadProcs(cfg); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+}
+
+func TestPrintSettings(t *testing.T) {
+	cfg := &config{
+		Port:      8555,
+		Formation: map[string]int{"web": 2},
+		Proxy:     proxyConfig{Listen: "127.0.0.1:8080"},
+		origins:   map[string]string{"formation": "-m", "proxy": ".goreman:1", "proxy.listen": ".goreman:2"},
+	}
+	var b strings.Builder
+	if err := printSettings(&b, reflect.ValueOf(cfg).Elem(), "", cfg.origins); err != nil {
+		t.Fatal(err)
+	}
+	// empty settings are printed too, with their own origin.
+	for _, want := range []string{
+		"port: 8555\t# default\n",
+		"socket: \"\"\t# default\n",
+		"listen: []\t# default\n",
+		"tls: false\t# default\n",
+		"formation.web: 2\t# -m\n",
+		"overlays: []\t# default\n",
+		"export.image: \"\"\t# default\n",
+		"procs: {}\t# default\n",
+		"proxy.listen: 127.0.0.1:8080\t# .goreman:2\n",
+		"proxy.domain: \"\"\t# default\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("output does not contain %q:\n%s", want, b.String())
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// splitLines splits s into lines, keeping the trailing newlines.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// unifiedDiff returns the differences between a and b in unified diff
+// format with three lines of context, or "" if they are equal.
+func unifiedDiff(aname, bname, a, b string) string {
+	x, y := splitLines(a), splitLines(b)
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:]
+	// and y[j:]. exported files are small enough for the quadratic table.
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	// edit script: ' ', '-' or '+' followed by the line.
+	type edit struct {
+		op   byte
+		line string
+	}
+	var edits []edit
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			edits = append(edits, edit{' ', x[i]})
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', x[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', y[j]})
+			j++
+		}
+	}
+
+	const context = 3
+	var sb strings.Builder
+	for start := 0; start < len(edits); {
+		// find the next change.
+		for start < len(edits) && edits[start].op == ' ' {
+			start++
+		}
+		if start == len(edits) {
+			break
+		}
+		// extend the hunk while changes are within 2*context lines.
+		end := start
+		for k := start; k < len(edits); k++ {
+			if edits[k].op != ' ' {
+				end = k + 1
+			} else if k-end >= 2*context {
+				break
+			}
+		}
+		from, to := max(start-context, 0), min(end+context, len(edits))
+
+		// line numbers of the hunk in a and b.
+		astart, bstart := 1, 1
+		for _, e := range edits[:from] {
+			if e.op != '+' {
+				astart++
+			}
+			if e.op != '-' {
+				bstart++
+			}
+		}
+		alen, blen := 0, 0
+		for _, e := range edits[from:to] {
+			if e.op != '+' {
+				alen++
+			}
+			if e.op != '-' {
+				blen++
+			}
+		}
+		if alen == 0 {
+			astart--
+		}
+		if blen == 0 {
+			bstart--
+		}
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", aname, bname)
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", astart, alen, bstart, blen)
+		for _, e := range edits[from:to] {
+			sb.WriteByte(e.op)
+			sb.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = to
+	}
+	return sb.String()
+}
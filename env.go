@@ -0,0 +1,426 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// interpolation modes.
+type interpMode int
+
+const (
+	// interpPlain expands every reference, and \$ is a literal $.
+	interpPlain interpMode = iota
+	// interpEscapes is interpPlain with the escapes of a double-quoted
+	// dotenv value: \n, \r and a backslash before any other character.
+	interpEscapes
+	// interpShell expands references outside single quotes and leaves
+	// escapes, unset variables and unknown forms such as ${VAR%.*} for the
+	// shell.
+	interpShell
+)
+
+var varNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*`)
+
+// interpolate expands $VAR, ${VAR}, ${VAR:-default}, ${VAR-default},
+// ${VAR:?message} and ${VAR?message} in s with the variables from lookup.
+// The colon forms also treat an empty variable as unset.
+func interpolate(s string, mode interpMode, lookup func(string) (string, bool)) (string, error) {
+	var b strings.Builder
+	var quote byte // quote of the shell word at i
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			}
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch {
+			case mode == interpShell:
+				b.WriteByte(c)
+			case mode == interpEscapes && s[i] == 'n':
+				b.WriteByte('\n')
+				continue
+			case mode == interpEscapes && s[i] == 'r':
+				b.WriteByte('\r')
+				continue
+			case mode == interpPlain && s[i] != '$':
+				b.WriteByte(c)
+			}
+			c = s[i]
+		case mode == interpShell && c == '\'' && quote == 0:
+			quote = c
+		case mode == interpShell && c == '"':
+			if quote == 0 {
+				quote = c
+			} else {
+				quote = 0
+			}
+		case c == '$':
+			v, n, err := expandRef(s[i:], mode, lookup)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(v)
+			i += n - 1
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), nil
+}
+
+// expandRef expands the reference at the start of s, and returns the number
+// of bytes it takes.
+func expandRef(s string, mode interpMode, lookup func(string) (string, bool)) (string, int, error) {
+	if name := varNameRe.FindString(s[1:]); name != "" {
+		if v, ok := lookup(name); ok {
+			return v, 1 + len(name), nil
+		}
+		if mode == interpShell {
+			return s[:1+len(name)], 1 + len(name), nil
+		}
+		return "", 1 + len(name), nil
+	}
+	if !strings.HasPrefix(s, "${") {
+		return "$", 1, nil
+	}
+
+	// find the matching brace.
+	end, depth := -1, 0
+	for i := 1; i < len(s) && end < 0; i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				end = i
+			}
+		}
+	}
+	if end < 0 {
+		if mode == interpShell {
+			return "$", 1, nil
+		}
+		return "", 0, fmt.Errorf("unterminated ${ in %q", s)
+	}
+	ref, inner := s[:end+1], s[2:end]
+	name := varNameRe.FindString(inner)
+	op, word := "", ""
+	for _, o := range []string{":-", ":?", "-", "?"} {
+		if strings.HasPrefix(inner[len(name):], o) {
+			op, word = o, inner[len(name)+len(o):]
+			break
+		}
+	}
+	if name == "" || op == "" && len(name) != len(inner) {
+		if mode == interpShell {
+			return ref, len(ref), nil
+		}
+		return "", 0, fmt.Errorf("bad substitution: %s", ref)
+	}
+
+	v, ok := lookup(name)
+	if strings.HasPrefix(op, ":") && v == "" {
+		ok = false
+	}
+	switch {
+	case ok:
+		return v, len(ref), nil
+	case op == "":
+		if mode == interpShell {
+			return ref, len(ref), nil
+		}
+		return "", len(ref), nil
+	case strings.HasSuffix(op, "-"):
+		v, err := interpolate(word, mode, lookup)
+		return v, len(ref), err
+	default:
+		if word == "" {
+			word = "is not set"
+		}
+		return "", 0, fmt.Errorf("%s: %s", name, word)
+	}
+}
+
+// lookupChain returns a lookup which tries each of lookups in order.
+func lookupChain(lookups ...func(string) (string, bool)) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		for _, lookup := range lookups {
+			if v, ok := lookup(key); ok {
+				return v, true
+			}
+		}
+		return "", false
+	}
+}
+
+// lookupMap returns a lookup of the variables in env.
+func lookupMap(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
+
+var dotenvKeyRe = regexp.MustCompile(`^(?:export\s+)?([A-Za-z_][A-Za-z0-9_.]*)\s*[=:]\s*`)
+
+// readEnvFile reads the dotenv file at path into env. A value may refer to
+// variables already in env and to variables from lookup. Values in single
+// quotes are taken literally, and values in double quotes may contain
+// escapes and span lines.
+func readEnvFile(path string, env map[string]string, lookup func(string) (string, bool)) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lookup = lookupChain(lookupMap(env), lookup)
+	content := strings.ReplaceAll(string(b), "\r\n", "\n")
+	var errs []error
+	errorf := func(line int, format string, a ...any) {
+		errs = append(errs, fmt.Errorf("%s:%d: %s", path, line, fmt.Sprintf(format, a...)))
+	}
+	line := 0
+	for content != "" {
+		line++
+		var l string
+		l, content, _ = strings.Cut(content, "\n")
+		l = strings.TrimSpace(l)
+		if l == "" || l[0] == '#' {
+			continue
+		}
+		m := dotenvKeyRe.FindStringSubmatch(l)
+		if m == nil {
+			errorf(line, "expected KEY=VALUE")
+			continue
+		}
+		key, value := m[1], l[len(m[0]):]
+		start := line
+
+		mode := interpPlain
+		switch {
+		case value != "" && (value[0] == '\'' || value[0] == '"'):
+			quote := value[0]
+			// find the closing quote, possibly on a later line.
+			rest := value[1:] + "\n" + content
+			end := -1
+			for i := 0; i < len(rest); i++ {
+				if rest[i] == '\\' && quote == '"' {
+					i++
+				} else if rest[i] == quote {
+					end = i
+					break
+				}
+			}
+			if end < 0 {
+				errorf(start, "unterminated %c quote", quote)
+				content = ""
+				continue
+			}
+			var trailing string
+			if first := len(value) - 1; end < first {
+				trailing = rest[end+1 : first]
+			} else {
+				line += strings.Count(rest[:end], "\n")
+				trailing, content, _ = strings.Cut(rest[end+1:], "\n")
+			}
+			value = rest[:end]
+			if t := strings.TrimSpace(trailing); t != "" && t[0] != '#' {
+				errorf(line, "unexpected %q after quoted value", t)
+				continue
+			}
+			if quote == '\'' {
+				env[key] = value
+				continue
+			}
+			mode = interpEscapes
+		default:
+			// an unquoted value ends at a comment.
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = value[:i]
+			}
+			value = strings.TrimSpace(value)
+		}
+		v, err := interpolate(value, mode, lookup)
+		if err != nil {
+			errorf(start, "%s: %v", key, err)
+			continue
+		}
+		env[key] = v
+	}
+	return errors.Join(errs...)
+}
+
+// readEnv reads the env files of cfg into cfg.env, later files overriding
+// earlier ones. Missing files are skipped.
+func readEnv(cfg *config) error {
+	cfg.env = map[string]string{}
+	var errs []error
+	for _, file := range cfg.EnvFiles {
+		if err := readEnvFile(file, cfg.env, os.LookupEnv); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// portEnv returns PORT and the named ports of the proc.
+func (proc *procInfo) portEnv() map[string]string {
+	env := map[string]string{}
+	for _, p := range proc.ports() {
+		env[p.env] = strconv.FormatUint(uint64(*p.port), 10)
+	}
+	return env
+}
+
+// mergeEnv returns environ with the variables of each layer added in order,
+// later values replacing earlier ones.
+func mergeEnv(environ []string, layers ...map[string]string) []string {
+	env := slices.Clone(environ)
+	index := map[string]int{}
+	for i, kv := range env {
+		k, _, _ := strings.Cut(kv, "=")
+		index[k] = i
+	}
+	for _, layer := range layers {
+		for _, k := range sortedKeys(layer) {
+			kv := k + "=" + layer[k]
+			if i, ok := index[k]; ok {
+				env[i] = kv
+			} else {
+				index[k] = len(env)
+				env = append(env, kv)
+			}
+		}
+	}
+	return env
+}
+
+// resolve expands the variables in the command of the proc, and sets the
+// command to run and the environment. Variables in a shell command are left
+// as references to the environment.
+func (proc *procInfo) resolve(cfg *config) error {
+	proc.environ = mergeEnv(os.Environ(), cfg.env, proc.env, proc.portEnv())
+	lookup := lookupChain(lookupMap(proc.portEnv()), lookupMap(proc.env), lookupMap(cfg.env), os.LookupEnv)
+	errorf := func(err error) error {
+		return fmt.Errorf("%s: %s: %v", proc.source, proc.name, err)
+	}
+	if len(proc.argv) > 0 {
+		proc.execArgs = make([]string, len(proc.argv))
+		for i, arg := range proc.argv {
+			v, err := interpolate(arg, interpPlain, lookup)
+			if err != nil {
+				return errorf(err)
+			}
+			proc.execArgs[i] = v
+		}
+		return nil
+	}
+	// the shell takes the values from the environment, so that they are not
+	// parsed as shell code and do not show in the command line. Defaults and
+	// required variables are still resolved here.
+	shellLookup := func(key string) (string, bool) {
+		v, ok := lookup(key)
+		if ok && v != "" {
+			return shellVar(key), true
+		}
+		return v, ok
+	}
+	v, err := interpolate(proc.cmdline, interpShell, shellLookup)
+	if err != nil {
+		return errorf(err)
+	}
+	proc.execArgs = append(cmdStart[:len(cmdStart):len(cmdStart)], v)
+	return nil
+}
+
+// procEnviron returns the environment and the directory of the proc name,
+// or the environment of the app if name is empty.
+func procEnviron(cfg *config, name string) ([]string, string, error) {
+	if name == "" {
+		if err := applyProfile(cfg); err != nil {
+			return nil, "", err
+		}
+		if err := readEnv(cfg); err != nil {
+			return nil, "", err
+		}
+		return mergeEnv(os.Environ(), cfg.env), "", nil
+	}
+	if err := loadProcs(cfg); err != nil {
+		return nil, "", err
+	}
+	proc := findProc(name)
+	if proc == nil {
+		return nil, "", errors.New("unknown proc: " + name)
+	}
+	return proc.environ, proc.dir, nil
+}
+
+// envFormats formats an environment for goreman env.
+var envFormats = map[string]func(w io.Writer, env map[string]string) error{
+	"dotenv": func(w io.Writer, env map[string]string) error {
+		for _, k := range sortedKeys(env) {
+			fmt.Fprintf(w, "%s=%s\n", k, dotenvQuote(env[k]))
+		}
+		return nil
+	},
+	"shell": func(w io.Writer, env map[string]string) error {
+		for _, k := range sortedKeys(env) {
+			fmt.Fprintf(w, "export %s=%s\n", k, shellQuote(env[k]))
+		}
+		return nil
+	},
+	"json": func(w io.Writer, env map[string]string) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(env)
+	},
+}
+
+// command: env. parses the options in args and prints the environment of
+// the proc given in args, or of the app.
+func envCommand(cfg *config, args []string) error {
+	fs := flag.NewFlagSet("env", flag.ExitOnError)
+	format := fs.String("format", "dotenv", "output format: dotenv, shell or json")
+	diff := fs.Bool("diff", false, "print only the variables which differ from the current environment")
+	secrets := fs.Bool("secrets", false, "print the values of secrets instead of masking them")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: goreman env [OPTIONS] [PROC]\n\nOptions:\n")
+		fs.PrintDefaults()
+		os.Exit(0)
+	}
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+	}
+	printEnv, ok := envFormats[*format]
+	if !ok {
+		return errors.New("unknown format: " + *format)
+	}
+	environ, _, err := procEnviron(cfg, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	env := map[string]string{}
+	for _, kv := range environ {
+		k, v, _ := strings.Cut(kv, "=")
+		if cur, ok := os.LookupEnv(k); *diff && ok && cur == v {
+			continue
+		}
+		env[k] = v
+	}
+	if proc := findProc(fs.Arg(0)); proc != nil && !*secrets {
+		proc.maskSecrets(env)
+	}
+	return printEnv(os.Stdout, env)
+}
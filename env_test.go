@@ -0,0 +1,281 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	lookup := lookupMap(map[string]string{"NAME": "web", "EMPTY": ""})
+	for _, tt := range []struct {
+		mode interpMode
+		in   string
+		want string
+		err  string
+	}{
+		{interpPlain, "$NAME ${NAME} x${NAME}y", "web web xweby", ""},
+		{interpPlain, "${UNSET} $UNSET.", " .", ""},
+		{interpPlain, "${EMPTY:-a} ${EMPTY-b} ${UNSET-c} ${UNSET:-$NAME}", "a  c web", ""},
+		{interpPlain, `\$NAME $ 5$`, "$NAME $ 5$", ""},
+		{interpPlain, "${NAME:?} ${EMPTY?}", "web ", ""},
+		{interpPlain, "${UNSET:?is required}", "", "UNSET: is required"},
+		{interpPlain, "${EMPTY:?}", "", "EMPTY: is not set"},
+		{interpPlain, "${NAME", "", "unterminated ${"},
+		{interpPlain, "${NAME%.*}", "", "bad substitution: ${NAME%.*}"},
+		{interpEscapes, `a\nb \"$NAME\" \\ \$`, "a\nb \"web\" \\ $", ""},
+		{interpShell, `echo $NAME "$NAME" '$NAME' \$NAME`, `echo web "web" '$NAME' \$NAME`, ""},
+		{interpShell, `echo $UNSET ${UNSET} $1 $$ ${#NAME} ${NAME%.*}`, `echo $UNSET ${UNSET} $1 $$ ${#NAME} ${NAME%.*}`, ""},
+		{interpShell, `echo ${UNSET:-"a b"} ${UNSET:?missing}`, "", "UNSET: missing"},
+	} {
+		got, err := interpolate(tt.in, tt.mode, lookup)
+		switch {
+		case tt.err != "":
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("interpolate(%q): want error %q, got %v", tt.in, tt.err, err)
+			}
+		case err != nil:
+			t.Errorf("interpolate(%q): %v", tt.in, err)
+		case got != tt.want:
+			t.Errorf("interpolate(%q): want %q, got %q", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestReadEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".env")
+	err := os.WriteFile(path, []byte(`# comment
+HOST=localhost
+export PORT: 5432 # inline comment
+URL=postgres://${HOST}:${PORT}/${DB:-app}
+LITERAL='$HOST # not a comment'
+QUOTED="a \"b\"\n$HOST"
+MULTI="first
+second"
+FROM_ENV=${GOREMAN_TEST_VAR}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{}
+	err = readEnvFile(path, env, lookupMap(map[string]string{"GOREMAN_TEST_VAR": "outer"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"HOST":     "localhost",
+		"PORT":     "5432",
+		"URL":      "postgres://localhost:5432/app",
+		"LITERAL":  "$HOST # not a comment",
+		"QUOTED":   "a \"b\"\nlocalhost",
+		"MULTI":    "first\nsecond",
+		"FROM_ENV": "outer",
+	}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("want %q, got %q", want, env)
+	}
+
+	err = os.WriteFile(path, []byte("A=1\nnot a variable\nB=${MISSING:?must be set}\nC=\"open\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = readEnvFile(path, map[string]string{}, lookupMap(nil))
+	for _, want := range []string{
+		path + ":2: expected KEY=VALUE",
+		path + ":3: B: MISSING: must be set",
+		path + ":4: unterminated \" quote",
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not contain %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestInterpolateProcfile(t *testing.T) {
+	dir := t.TempDir()
+	procfile := filepath.Join(dir, "Procfile")
+	err := os.WriteFile(procfile, []byte("web: ./web -p $PORT -d ${DB:-app} -h $GOREMAN_TEST_HOST\nworker: ./worker ${QUEUE:?set QUEUE}\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	envFile := filepath.Join(dir, ".env")
+	if err := os.WriteFile(envFile, []byte("GOREMAN_TEST_HOST=example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config{Procfile: procfile, EnvFiles: []string{envFile}, BasePort: 5000}
+	err = loadProcs(cfg)
+	if want := procfile + ":2: worker: QUEUE: set QUEUE"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("error does not contain %q:\n%v", want, err)
+	}
+	if got, want := findProc("web").execArgs, append(cmdStart, "./web -p "+shellVar("PORT")+" -d app -h "+shellVar("GOREMAN_TEST_HOST")); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestInterpolateProcfileMetachars(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+	value := `postgres://h/db?ssl=1&pool=5;echo "injected";$(echo injected)`
+	t.Setenv("GOREMAN_TEST_DBURL", value)
+	procfile := filepath.Join(t.TempDir(), "Procfile")
+	err := os.WriteFile(procfile, []byte("web: printf '%s\\n' $GOREMAN_TEST_DBURL \"${GOREMAN_TEST_DBURL:-none}\"\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loadProcs(&config{Procfile: procfile}); err != nil {
+		t.Fatal(err)
+	}
+	web := findProc("web")
+	if args := strings.Join(web.execArgs, " "); strings.Contains(args, "postgres") {
+		t.Errorf("command line contains the value: %s", args)
+	}
+	cmd := exec.Command(web.execArgs[0], web.execArgs[1:]...)
+	cmd.Env = web.environ
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the unquoted value is split into words, but not parsed as shell code.
+	if got, want := string(out), strings.ReplaceAll(value, " ", "\n")+"\n"+value+"\n"; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestProcEnviron(t *testing.T) {
+	t.Setenv("GOREMAN_TEST_SHELL", "shell")
+	t.Setenv("GOREMAN_TEST_GLOBAL", "shell")
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"Procfile": "web: ./web\nworker: ./worker\n",
+		".env":     "GOREMAN_TEST_GLOBAL=global\nGOREMAN_TEST_FILE=global\nGOREMAN_TEST_INLINE=global\n",
+		".env.web": "GOREMAN_TEST_FILE=file\nGOREMAN_TEST_INLINE=file\nGOREMAN_TEST_REF=$GOREMAN_TEST_GLOBAL\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &config{
+		Procfile: filepath.Join(dir, "Procfile"),
+		EnvFiles: []string{filepath.Join(dir, ".env")},
+		BasePort: 5000,
+		Procs: map[string]*procConfig{
+			"web": {
+				EnvFile: []string{filepath.Join(dir, ".env.web")},
+				Env:     map[string]string{"GOREMAN_TEST_INLINE": "inline"},
+			},
+		},
+	}
+	if err := loadProcs(cfg); err != nil {
+		t.Fatal(err)
+	}
+	environ := func(name string) map[string]string {
+		env := map[string]string{}
+		for _, kv := range findProc(name).environ {
+			if k, v, _ := strings.Cut(kv, "="); strings.HasPrefix(k, "GOREMAN_TEST_") || k == "PORT" {
+				if _, dup := env[k]; dup {
+					t.Errorf("%s: duplicate %s", name, k)
+				}
+				env[k] = v
+			}
+		}
+		return env
+	}
+	if got, want := environ("web"), map[string]string{
+		"GOREMAN_TEST_SHELL":  "shell",
+		"GOREMAN_TEST_GLOBAL": "global",
+		"GOREMAN_TEST_FILE":   "file",
+		"GOREMAN_TEST_INLINE": "inline",
+		"GOREMAN_TEST_REF":    "global",
+		"PORT":                "5000",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("web: want %q, got %q", want, got)
+	}
+	if got, want := environ("worker"), map[string]string{
+		"GOREMAN_TEST_SHELL":  "shell",
+		"GOREMAN_TEST_GLOBAL": "global",
+		"GOREMAN_TEST_FILE":   "global",
+		"GOREMAN_TEST_INLINE": "global",
+		"PORT":                "5100",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("worker: want %q, got %q", want, got)
+	}
+	// the environment of goreman itself is left alone.
+	if v := os.Getenv("GOREMAN_TEST_GLOBAL"); v != "shell" {
+		t.Errorf("GOREMAN_TEST_GLOBAL was changed to %q", v)
+	}
+	if _, ok := os.LookupEnv("GOREMAN_TEST_FILE"); ok {
+		t.Error("GOREMAN_TEST_FILE leaked into the environment")
+	}
+}
+
+func TestEnvCommand(t *testing.T) {
+	t.Setenv("GOREMAN_TEST_SAME", "same")
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"Procfile": "web: ./web\n",
+		".env":     "GOREMAN_TEST_SAME=same\nGOREMAN_TEST_URL=\"postgres://localhost/app?a=1&b=$$\"\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newConfig := func() *config {
+		return &config{
+			Procfile: filepath.Join(dir, "Procfile"),
+			EnvFiles: []string{filepath.Join(dir, ".env")},
+			BasePort: 5000,
+			Procs: map[string]*procConfig{
+				"web": {Env: map[string]string{"GOREMAN_TEST_QUOTE": "it's \"quoted\"\nand \\$escaped"}},
+			},
+		}
+	}
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-diff", "web"}, `GOREMAN_TEST_QUOTE="it's \"quoted\"\nand \$escaped"
+GOREMAN_TEST_URL="postgres://localhost/app?a=1&b=\$\$"
+PORT="5000"
+`},
+		{[]string{"-diff", "-format", "shell"}, `export GOREMAN_TEST_URL='postgres://localhost/app?a=1&b=$$'
+`},
+		{[]string{"-diff", "-format", "json", "web"}, `{
+  "GOREMAN_TEST_QUOTE": "it's \"quoted\"\nand $escaped",
+  "GOREMAN_TEST_URL": "postgres://localhost/app?a=1\u0026b=$$",
+  "PORT": "5000"
+}
+`},
+	} {
+		got := captureStdout(t, func() error { return envCommand(newConfig(), tt.args) })
+		if got != tt.want {
+			t.Errorf("goreman env %q:\nwant:\n%s\ngot:\n%s", tt.args, tt.want, got)
+		}
+	}
+
+	// the whole environment includes the unchanged variables, and the
+	// dotenv output reads back to the same environment.
+	out := captureStdout(t, func() error { return envCommand(newConfig(), []string{"web"}) })
+	path := filepath.Join(dir, "env.out")
+	if err := os.WriteFile(path, []byte(out), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{}
+	if err := readEnvFile(path, env, lookupMap(nil)); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{
+		"GOREMAN_TEST_SAME":  "same",
+		"GOREMAN_TEST_QUOTE": "it's \"quoted\"\nand $escaped",
+		"PORT":               "5000",
+	} {
+		if env[k] != v {
+			t.Errorf("%s: want %q, got %q", k, v, env[k])
+		}
+	}
+}
@@ -1,71 +1,232 @@
 package main
 
 import (
+	"bytes"
 	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
-
-	"github.com/joho/godotenv"
 )
 
-func exportUpstart(cfg *config, path string) error {
-	procfile, err := filepath.Abs(cfg.Procfile)
-	if err != nil {
-		return err
-	}
-	// parse .env the same way `goreman start` does (godotenv), so exported
-	// values match the runtime environment.
-	env, err := godotenv.Read(filepath.Join(filepath.Dir(procfile), ".env"))
-	if err != nil {
-		env = map[string]string{}
+// exportOptions are the options shared by every export format.
+type exportOptions struct {
+	// Application name used to prefix service names. Defaults to "app".
+	App string `yaml:"app,omitempty"`
+	// User the procs run as. Defaults to the application name.
+	User string `yaml:"user,omitempty"`
+	// Directory for log files. Defaults to /var/log/APP.
+	LogDir string `yaml:"log_dir,omitempty"`
+	// Working directory of the procs. Defaults to the Procfile directory.
+	WorkDir string `yaml:"work_dir,omitempty"`
+	// Container image for the kubernetes export.
+	Image string `yaml:"image,omitempty"`
+	// Directory of templates for the custom export.
+	Template string `yaml:"template,omitempty"`
+	// If true, write the values of secrets instead of masking them.
+	Secrets bool `yaml:"-"`
+	// If true, print the generated files instead of writing them.
+	DryRun bool `yaml:"-"`
+	// If true, print the differences to the files in the export location
+	// instead of writing them.
+	Diff bool `yaml:"-"`
+}
+
+// exportFile is a file generated by an export format.
+type exportFile struct {
+	name string
+	mode os.FileMode
+	bytes.Buffer
+}
+
+// exportFormats generate the files of each export format.
+var exportFormats = map[string]func(cfg *config, data *exportData) ([]*exportFile, error){
+	"upstart":    exportUpstart,
+	"kubernetes": exportKubernetes,
+	"launchd":    exportLaunchd,
+	"openrc":     exportOpenRC,
+	"custom":     exportTemplate,
+}
+
+// exportEnv reads the env files of cfg the same way `goreman start` does,
+// so exported values match the runtime environment. Variables which are not
+// set in the env files are left as references instead of taking their value
+// from the local environment.
+func exportEnv(cfg *config) (map[string]string, error) {
+	env := map[string]string{}
+	lookup := func(name string) (string, bool) { return "${" + name + "}", true }
+	var errs []error
+	for _, file := range cfg.EnvFiles {
+		if err := readEnvFile(file, env, lookup); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
 	}
+	return env, errors.Join(errs...)
+}
 
-	for _, proc := range procs {
-		f, err := os.Create(filepath.Join(path, "app-"+proc.name+".conf"))
-		if err != nil {
-			return err
+// staleExportFiles returns the files in path whose name starts with app and
+// which are not among files.
+func staleExportFiles(path, app string, files []*exportFile) ([]string, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var stale []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, app) {
+			continue
 		}
+		if !slices.ContainsFunc(files, func(f *exportFile) bool { return f.name == name }) {
+			stale = append(stale, filepath.Join(path, name))
+		}
+	}
+	return stale, nil
+}
 
-		fmt.Fprintf(f, "start on starting app-%s\n", proc.name)
-		fmt.Fprintf(f, "stop on stopping app-%s\n", proc.name)
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func exportUpstart(cfg *config, data *exportData) ([]*exportFile, error) {
+	var files []*exportFile
+	for _, proc := range data.Procs {
+		f := &exportFile{name: data.App + "-" + proc.Name + ".conf", mode: 0644}
+		files = append(files, f)
+
+		fmt.Fprintf(f, "start on starting %s-%s\n", data.App, proc.Name)
+		fmt.Fprintf(f, "stop on stopping %s-%s\n", data.App, proc.Name)
 		fmt.Fprintf(f, "respawn\n")
 		fmt.Fprintf(f, "\n")
 
-		if proc.setPort {
-			fmt.Fprintf(f, "env PORT=%d\n", proc.port)
-		}
-		for k, v := range env {
-			fmt.Fprintf(f, "env %s='%s'\n", k, strings.Replace(v, "'", "\\'", -1))
+		for _, k := range sortedKeys(proc.Env) {
+			v, err := upstartQuote(proc.Env[k])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %v", proc.Name, k, err)
+			}
+			fmt.Fprintf(f, "env %s=%s\n", k, v)
 		}
 		fmt.Fprintf(f, "\n")
-		fmt.Fprintf(f, "setuid app\n")
+		fmt.Fprintf(f, "setuid %s\n", data.User)
 		fmt.Fprintf(f, "\n")
-		fmt.Fprintf(f, "chdir %s\n", filepath.ToSlash(filepath.Dir(procfile)))
+		fmt.Fprintf(f, "chdir %s\n", proc.Dir)
 		fmt.Fprintf(f, "\n")
-		fmt.Fprintf(f, "exec %s\n", proc.cmdline)
+		logFile := shellQuote(data.LogDir + "/" + proc.Name + ".log")
+		if len(proc.Args) > 0 {
+			// upstart runs the line with sh for the redirection, which
+			// takes the quoted arguments as they are.
+			fmt.Fprintf(f, "exec %s >> %s 2>&1\n", shellJoin(proc.Args), logFile)
+		} else {
+			fmt.Fprintf(f, "exec /bin/sh -c %s\n", shellQuote(proc.Command+" >> "+logFile+" 2>&1"))
+		}
+	}
+	return files, nil
+}
 
-		f.Close()
+// command: export. parses the export options in args and exports the
+// format given in args to the location given in args.
+func exportCommand(cfg *config, args []string) error {
+	opts := &cfg.Export
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.StringVar(&opts.App, "app", opts.App, "application name (default \"app\")")
+	fs.StringVar(&opts.User, "user", opts.User, "user to run the procs as (default application name)")
+	fs.StringVar(&opts.LogDir, "log", opts.LogDir, "directory for log files (default /var/log/APP)")
+	fs.StringVar(&opts.WorkDir, "workdir", opts.WorkDir, "working directory of the procs (default Procfile directory)")
+	fs.StringVar(&opts.Image, "image", opts.Image, "container image for kubernetes export")
+	fs.StringVar(&opts.Template, "template", opts.Template, "directory of templates for custom export")
+	fs.BoolVar(&opts.Secrets, "secrets", false, "write the values of secrets instead of masking them")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "print the generated files instead of writing them")
+	fs.BoolVar(&opts.Diff, "diff", false, "show the differences to an existing export instead of writing it")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: goreman export [OPTIONS] FORMAT LOCATION\n\nOptions:\n")
+		fs.PrintDefaults()
+		os.Exit(0)
 	}
-	return nil
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+	}
+	return export(cfg, fs.Arg(0), fs.Arg(1))
 }
 
-// command: export.
 func export(cfg *config, format, path string) error {
-	err := readProcfile(cfg)
+	exportFormat, ok := exportFormats[format]
+	if !ok {
+		return errors.New("unknown format: " + format)
+	}
+
+	err := loadProcs(cfg)
+	if err != nil {
+		return err
+	}
+	data, err := newExportData(cfg)
 	if err != nil {
 		return err
 	}
+	files, err := exportFormat(cfg, data)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case cfg.Export.DryRun:
+		for _, f := range files {
+			fmt.Fprintf(os.Stdout, "# %s\n", filepath.Join(path, f.name))
+			os.Stdout.Write(f.Bytes())
+		}
+		return nil
+	case cfg.Export.Diff:
+		for _, f := range files {
+			filename := filepath.Join(path, f.name)
+			old, err := os.ReadFile(filename)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			oldname := filename
+			if err != nil {
+				oldname = os.DevNull
+			}
+			os.Stdout.WriteString(unifiedDiff(oldname, filename, string(old), f.String()))
+		}
+		// files of the app which the export would not write are stale.
+		stale, err := staleExportFiles(path, data.App, files)
+		if err != nil {
+			return err
+		}
+		for _, filename := range stale {
+			old, err := os.ReadFile(filename)
+			if err != nil {
+				return err
+			}
+			os.Stdout.WriteString(unifiedDiff(filename, os.DevNull, string(old), ""))
+		}
+		return nil
+	}
 
 	err = os.MkdirAll(path, 0755)
 	if err != nil {
 		return err
 	}
-
-	switch format {
-	case "upstart":
-		return exportUpstart(cfg, path)
+	for _, f := range files {
+		filename := filepath.Join(path, f.name)
+		err = os.WriteFile(filename, f.Bytes(), f.mode)
+		if err != nil {
+			return err
+		}
+		// WriteFile keeps the mode of an existing file.
+		err = os.Chmod(filename, f.mode)
+		if err != nil {
+			return err
+		}
 	}
-	return errors.New("unknown format: " + format)
+	return nil
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// kubeName converts s into a valid kubernetes object name (RFC 1123 label).
+func kubeName(s string) string {
+	b := []byte(strings.ToLower(s))
+	for i, c := range b {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			b[i] = '-'
+		}
+	}
+	return strings.Trim(string(b), "-")
+}
+
+// kubeEscape escapes $ in s, which kubernetes expands as $(VAR) in the
+// command, args and env values of a container.
+func kubeEscape(s string) string {
+	return strings.ReplaceAll(s, "$", "$$")
+}
+
+// kubeProbe returns the body of a probe for the health check of proc, or ""
+// if it has none.
+func kubeProbe(proc *exportProc) string {
+	hc := proc.HealthCheck
+	if hc == nil {
+		return ""
+	}
+	var b strings.Builder
+	switch {
+	case hc.Command != "":
+		fmt.Fprintf(&b, "          exec:\n")
+		fmt.Fprintf(&b, "            command: [\"/bin/sh\", \"-c\", %s]\n", yamlQuote(hc.Command))
+	case strings.Contains(hc.HTTP, "://"):
+		// a full URL may point anywhere, so let curl request it.
+		fmt.Fprintf(&b, "          exec:\n")
+		fmt.Fprintf(&b, "            command: [\"curl\", \"-fsS\", %s]\n", yamlQuote(hc.HTTP))
+	case hc.HTTP != "" && proc.Port != 0:
+		fmt.Fprintf(&b, "          httpGet:\n")
+		fmt.Fprintf(&b, "            path: %s\n", yamlQuote("/"+strings.TrimPrefix(hc.HTTP, "/")))
+		fmt.Fprintf(&b, "            port: %d\n", proc.Port)
+	default:
+		return ""
+	}
+	fmt.Fprintf(&b, "          periodSeconds: %d\n", max(int(hc.interval().Seconds()), 1))
+	fmt.Fprintf(&b, "          timeoutSeconds: %d\n", max(int(hc.timeout().Seconds()), 1))
+	return b.String()
+}
+
+func exportKubernetes(cfg *config, data *exportData) ([]*exportFile, error) {
+	if cfg.Export.Image == "" {
+		return nil, errors.New("kubernetes export requires an image (-image)")
+	}
+	app := kubeName(data.App)
+
+	var files []*exportFile
+	if len(data.Env) > 0 {
+		f := &exportFile{name: app + "-env.yaml", mode: 0644}
+		files = append(files, f)
+
+		fmt.Fprintf(f, "apiVersion: v1\n")
+		fmt.Fprintf(f, "kind: ConfigMap\n")
+		fmt.Fprintf(f, "metadata:\n")
+		fmt.Fprintf(f, "  name: %s-env\n", app)
+		fmt.Fprintf(f, "data:\n")
+		for _, k := range sortedKeys(data.Env) {
+			fmt.Fprintf(f, "  %s: %s\n", yamlQuote(k), yamlQuote(data.Env[k]))
+		}
+	}
+
+	for _, proc := range data.Procs {
+		name := app + "-" + kubeName(proc.Name)
+		f := &exportFile{name: name + ".yaml", mode: 0644}
+		files = append(files, f)
+
+		fmt.Fprintf(f, "apiVersion: apps/v1\n")
+		fmt.Fprintf(f, "kind: Deployment\n")
+		fmt.Fprintf(f, "metadata:\n")
+		fmt.Fprintf(f, "  name: %s\n", name)
+		fmt.Fprintf(f, "  labels:\n")
+		fmt.Fprintf(f, "    app: %s\n", app)
+		fmt.Fprintf(f, "    process: %s\n", kubeName(proc.Name))
+		fmt.Fprintf(f, "spec:\n")
+		fmt.Fprintf(f, "  replicas: %d\n", proc.Instances)
+		fmt.Fprintf(f, "  selector:\n")
+		fmt.Fprintf(f, "    matchLabels:\n")
+		fmt.Fprintf(f, "      app: %s\n", app)
+		fmt.Fprintf(f, "      process: %s\n", kubeName(proc.Name))
+		fmt.Fprintf(f, "  template:\n")
+		fmt.Fprintf(f, "    metadata:\n")
+		fmt.Fprintf(f, "      labels:\n")
+		fmt.Fprintf(f, "        app: %s\n", app)
+		fmt.Fprintf(f, "        process: %s\n", kubeName(proc.Name))
+		fmt.Fprintf(f, "    spec:\n")
+		fmt.Fprintf(f, "      containers:\n")
+		fmt.Fprintf(f, "      - name: %s\n", kubeName(proc.Name))
+		fmt.Fprintf(f, "        image: %s\n", yamlQuote(cfg.Export.Image))
+		if len(proc.Args) > 0 {
+			args := make([]string, len(proc.Args))
+			for i, arg := range proc.Args {
+				args[i] = yamlQuote(kubeEscape(arg))
+			}
+			fmt.Fprintf(f, "        command: [%s]\n", strings.Join(args, ", "))
+		} else {
+			fmt.Fprintf(f, "        command: [\"/bin/sh\", \"-c\"]\n")
+			fmt.Fprintf(f, "        args: [%s]\n", yamlQuote(kubeEscape(proc.Command)))
+		}
+		if cfg.Export.WorkDir != "" {
+			// the Procfile directory only makes sense on this host, so
+			// only an explicit working directory is used in the image.
+			fmt.Fprintf(f, "        workingDir: %s\n", yamlQuote(cfg.Export.WorkDir))
+		}
+		if len(data.Env) > 0 {
+			fmt.Fprintf(f, "        envFrom:\n")
+			fmt.Fprintf(f, "        - configMapRef:\n")
+			fmt.Fprintf(f, "            name: %s-env\n", app)
+		}
+		// the ConfigMap holds .env, the rest of the environment of the
+		// proc (PORT and settings from .goreman) is set on the container.
+		var env []string
+		for _, k := range sortedKeys(proc.Env) {
+			if v, ok := data.Env[k]; !ok || v != proc.Env[k] {
+				env = append(env, k)
+			}
+		}
+		if len(env) > 0 {
+			fmt.Fprintf(f, "        env:\n")
+			for _, k := range env {
+				fmt.Fprintf(f, "        - name: %s\n", yamlQuote(k))
+				fmt.Fprintf(f, "          value: %s\n", yamlQuote(kubeEscape(proc.Env[k])))
+			}
+		}
+		if probe := kubeProbe(proc); probe != "" {
+			fmt.Fprintf(f, "        livenessProbe:\n%s", probe)
+			fmt.Fprintf(f, "        readinessProbe:\n%s", probe)
+		}
+		if proc.Port != 0 {
+			fmt.Fprintf(f, "        ports:\n")
+			fmt.Fprintf(f, "        - containerPort: %d\n", proc.Port)
+
+			fmt.Fprintf(f, "---\n")
+			fmt.Fprintf(f, "apiVersion: v1\n")
+			fmt.Fprintf(f, "kind: Service\n")
+			fmt.Fprintf(f, "metadata:\n")
+			fmt.Fprintf(f, "  name: %s\n", name)
+			fmt.Fprintf(f, "  labels:\n")
+			fmt.Fprintf(f, "    app: %s\n", app)
+			fmt.Fprintf(f, "    process: %s\n", kubeName(proc.Name))
+			fmt.Fprintf(f, "spec:\n")
+			fmt.Fprintf(f, "  selector:\n")
+			fmt.Fprintf(f, "    app: %s\n", app)
+			fmt.Fprintf(f, "    process: %s\n", kubeName(proc.Name))
+			fmt.Fprintf(f, "  ports:\n")
+			fmt.Fprintf(f, "  - port: %d\n", proc.Port)
+			fmt.Fprintf(f, "    targetPort: %d\n", proc.Port)
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+)
+
+// plistString returns s as a plist <string> element.
+func plistString(s string) string {
+	return "<string>" + xmlEscape(s) + "</string>"
+}
+
+func exportLaunchd(cfg *config, data *exportData) ([]*exportFile, error) {
+	var files []*exportFile
+	for _, proc := range data.Procs {
+		label := data.App + "-" + proc.Name
+		f := &exportFile{name: label + ".plist", mode: 0644}
+		files = append(files, f)
+
+		fmt.Fprintf(f, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+		fmt.Fprintf(f, "<!DOCTYPE plist PUBLIC \"-//Apple//DTD PLIST 1.0//EN\" \"http://www.apple.com/DTDs/PropertyList-1.0.dtd\">\n")
+		fmt.Fprintf(f, "<plist version=\"1.0\">\n")
+		fmt.Fprintf(f, "<dict>\n")
+		fmt.Fprintf(f, "  <key>Label</key>\n")
+		fmt.Fprintf(f, "  %s\n", plistString(label))
+		fmt.Fprintf(f, "  <key>ProgramArguments</key>\n")
+		fmt.Fprintf(f, "  <array>\n")
+		for _, arg := range proc.argv() {
+			fmt.Fprintf(f, "    %s\n", plistString(arg))
+		}
+		fmt.Fprintf(f, "  </array>\n")
+		fmt.Fprintf(f, "  <key>EnvironmentVariables</key>\n")
+		fmt.Fprintf(f, "  <dict>\n")
+		for _, k := range sortedKeys(proc.Env) {
+			fmt.Fprintf(f, "    <key>%s</key>\n", xmlEscape(k))
+			fmt.Fprintf(f, "    %s\n", plistString(proc.Env[k]))
+		}
+		fmt.Fprintf(f, "  </dict>\n")
+		fmt.Fprintf(f, "  <key>WorkingDirectory</key>\n")
+		fmt.Fprintf(f, "  %s\n", plistString(proc.Dir))
+		fmt.Fprintf(f, "  <key>RunAtLoad</key>\n")
+		fmt.Fprintf(f, "  <true/>\n")
+		fmt.Fprintf(f, "  <key>UserName</key>\n")
+		fmt.Fprintf(f, "  %s\n", plistString(data.User))
+		fmt.Fprintf(f, "  <key>KeepAlive</key>\n")
+		fmt.Fprintf(f, "  <true/>\n")
+		fmt.Fprintf(f, "  <key>StandardOutPath</key>\n")
+		fmt.Fprintf(f, "  %s\n", plistString(data.LogDir+"/"+proc.Name+".log"))
+		fmt.Fprintf(f, "  <key>StandardErrorPath</key>\n")
+		fmt.Fprintf(f, "  %s\n", plistString(data.LogDir+"/"+proc.Name+".error.log"))
+		fmt.Fprintf(f, "</dict>\n")
+		fmt.Fprintf(f, "</plist>\n")
+	}
+	return files, nil
+}
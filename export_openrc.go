@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+)
+
+func exportOpenRC(cfg *config, data *exportData) ([]*exportFile, error) {
+	var files []*exportFile
+	for _, proc := range data.Procs {
+		f := &exportFile{name: data.App + "-" + proc.Name, mode: 0755}
+		files = append(files, f)
+
+		fmt.Fprintf(f, "#!/sbin/openrc-run\n")
+		fmt.Fprintf(f, "\n")
+		fmt.Fprintf(f, "description=%s\n", shellQuote(data.App+" "+proc.Name))
+		fmt.Fprintf(f, "supervisor=supervise-daemon\n")
+		argv := proc.argv()
+		fmt.Fprintf(f, "command=%s\n", shellJoin(argv[:1]))
+		fmt.Fprintf(f, "command_args=%s\n", shellQuote(shellJoin(argv[1:])))
+		fmt.Fprintf(f, "command_user=%s\n", shellQuote(data.User))
+		fmt.Fprintf(f, "directory=%s\n", shellQuote(proc.Dir))
+		fmt.Fprintf(f, "output_log=%s\n", shellQuote(data.LogDir+"/"+proc.Name+".log"))
+		fmt.Fprintf(f, "error_log=%s\n", shellQuote(data.LogDir+"/"+proc.Name+".error.log"))
+		fmt.Fprintf(f, "\n")
+		for _, k := range sortedKeys(proc.Env) {
+			fmt.Fprintf(f, "export %s=%s\n", k, shellQuote(proc.Env[k]))
+		}
+		fmt.Fprintf(f, "\n")
+		fmt.Fprintf(f, "depend() {\n")
+		fmt.Fprintf(f, "\tneed net\n")
+		fmt.Fprintf(f, "\tuse logger\n")
+		fmt.Fprintf(f, "}\n")
+	}
+	return files, nil
+}
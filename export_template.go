@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// exportData is the data model passed to user-supplied export templates.
+type exportData struct {
+	App       string            // application name, e.g. "app"
+	User      string            // user the procs run as
+	WorkDir   string            // working directory of the procs
+	LogDir    string            // directory for log files
+	Env       map[string]string // environment read from .env
+	Formation map[string]int    // number of instances of each proc
+	Procs     []*exportProc     // procs in Procfile order
+	Proc      *exportProc       // current proc, for per-proc templates
+}
+
+// exportProc describes a single proc in exportData.
+type exportProc struct {
+	Name        string            // name in the Procfile
+	Command     string            // command line in the Procfile
+	Args        []string          // argv run without a shell, or nil
+	Dir         string            // working directory of the proc
+	Port        uint              // value of PORT, or 0 if unset
+	Instances   int               // number of instances from the formation
+	Env         map[string]string // environment of the proc, including PORT
+	HealthCheck *healthCheck      // health check from .goreman, or nil
+}
+
+// argv returns the arguments which run the proc.
+func (p *exportProc) argv() []string {
+	if len(p.Args) > 0 {
+		return p.Args
+	}
+	return []string{"/bin/sh", "-c", p.Command}
+}
+
+// exportFuncs are the functions available to export templates.
+var exportFuncs = template.FuncMap{
+	"shellquote":       shellQuote,
+	"upstartquote":     upstartQuote,
+	"systemdquote":     systemdQuote,
+	"systemdexecquote": systemdExecQuote,
+	"yamlquote":        yamlQuote,
+	"xmlescape":        xmlEscape,
+	"join":             strings.Join,
+}
+
+// newExportData builds the template data model from the loaded procs.
+func newExportData(cfg *config) (*exportData, error) {
+	procfile, err := filepath.Abs(cfg.Procfile)
+	if err != nil {
+		return nil, err
+	}
+	env, err := exportEnv(cfg)
+	if err != nil {
+		return nil, err
+	}
+	opts := cfg.Export
+	data := &exportData{
+		App:       opts.App,
+		User:      opts.User,
+		WorkDir:   opts.WorkDir,
+		LogDir:    opts.LogDir,
+		Env:       env,
+		Formation: map[string]int{},
+	}
+	if data.App == "" {
+		data.App = "app"
+	}
+	if data.User == "" {
+		data.User = data.App
+	}
+	if data.WorkDir == "" {
+		data.WorkDir = filepath.ToSlash(filepath.Dir(procfile))
+	}
+	if data.LogDir == "" {
+		data.LogDir = "/var/log/" + data.App
+	}
+	for _, proc := range procs {
+		p := &exportProc{
+			Name:        proc.name,
+			Command:     proc.cmdline,
+			Args:        proc.argv,
+			Dir:         data.WorkDir,
+			Instances:   cfg.instances(proc.name),
+			Env:         map[string]string{},
+			HealthCheck: proc.healthCheck,
+		}
+		if proc.dir != "" {
+			p.Dir = proc.dir
+			if !filepath.IsAbs(proc.dir) {
+				p.Dir = path.Join(data.WorkDir, filepath.ToSlash(proc.dir))
+			}
+		}
+		for k, v := range data.Env {
+			p.Env[k] = v
+		}
+		for k, v := range proc.env {
+			p.Env[k] = v
+		}
+		if !opts.Secrets {
+			proc.maskSecrets(p.Env)
+		}
+		if proc.setPort {
+			p.Port = proc.port
+		}
+		for k, v := range proc.portEnv() {
+			p.Env[k] = v
+		}
+		data.Formation[proc.name] = p.Instances
+		data.Procs = append(data.Procs, p)
+	}
+	return data, nil
+}
+
+// exportTemplate renders every file in the template directory. "APP" in a
+// file name is replaced by the app name; a file whose name contains "PROC"
+// is rendered once per proc with .Proc set and "PROC" replaced by the proc
+// name. A trailing ".tmpl" is removed from the output name.
+func exportTemplate(cfg *config, data *exportData) ([]*exportFile, error) {
+	dir := cfg.Export.Template
+	if dir == "" {
+		return nil, errors.New("custom export requires a template directory (-template)")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*exportFile
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		b, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		tmpl, err := template.New(entry.Name()).Funcs(exportFuncs).Option("missingkey=error").Parse(string(b))
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSuffix(entry.Name(), ".tmpl")
+		name = strings.Replace(name, "APP", data.App, -1)
+		if !strings.Contains(name, "PROC") {
+			f := &exportFile{name: name, mode: info.Mode().Perm()}
+			if err := tmpl.Execute(f, data); err != nil {
+				return nil, err
+			}
+			files = append(files, f)
+			continue
+		}
+		for _, proc := range data.Procs {
+			d := *data
+			d.Proc = proc
+			f := &exportFile{name: strings.Replace(name, "PROC", proc.Name, -1), mode: info.Mode().Perm()}
+			if err := tmpl.Execute(f, &d); err != nil {
+				return nil, err
+			}
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// goldenProcfile and goldenEnv are exported by the golden file tests.
+const goldenProcfile = `web: bundle exec rackup -p $PORT
+worker: echo "it's a <worker> & more"
+`
+
+const goldenEnv = `DATABASE_URL=postgres://localhost/app
+GREETING="it's <b> & \"more\""
+MOTD="first line\nsecond line"
+PRICE='costs $5 or 100%'
+`
+
+// testGolden exports format and compares every generated file against
+// testdata/<format>. The temporary project directory is replaced by
+// /srv/app so the golden files are stable.
+func testGolden(t *testing.T, cfg *config, format string) {
+	t.Helper()
+	out := t.TempDir()
+	if err := export(cfg, format, out); err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", format)
+	if *update {
+		os.RemoveAll(golden)
+		if err := os.MkdirAll(golden, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dir := filepath.ToSlash(filepath.Dir(cfg.Procfile))
+	files, err := os.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		got, err := os.ReadFile(filepath.Join(out, file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = bytes.ReplaceAll(got, []byte(dir), []byte("/srv/app"))
+		if *update {
+			if err := os.WriteFile(filepath.Join(golden, file.Name()), got, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(filepath.Join(golden, file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s/%s differs from golden file:\n%s", format, file.Name(), got)
+		}
+	}
+	if want, err := os.ReadDir(golden); err == nil && len(want) != len(files) {
+		t.Errorf("%s: got %d files, want %d", format, len(files), len(want))
+	}
+}
+
+// writeExportProcfile writes a Procfile and .env into a temporary directory
+// and returns a config pointing at them.
+func writeExportProcfile(t *testing.T, procfile, env string) *config {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "Procfile"), []byte(procfile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if env != "" {
+		if err := os.WriteFile(filepath.Join(dir, ".env"), []byte(env), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &config{
+		Procfile: filepath.Join(dir, "Procfile"),
+		EnvFiles: []string{filepath.Join(dir, ".env")},
+		BasePort: 5000,
+	}
+}
+
+func TestExportKubernetes(t *testing.T) {
+	cfg := writeExportProcfile(t, "web: ruby app.rb -p $PORT\nworker: ruby worker.rb\n", "A=1\n")
+	cfg.Export.Image = "example/app:1"
+	cfg.Formation = map[string]int{"worker": 2}
+	out := t.TempDir()
+	if err := export(cfg, "kubernetes", out); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"app-env.yaml", "app-web.yaml", "app-worker.yaml"} {
+		if _, err := os.Stat(filepath.Join(out, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := os.ReadFile(filepath.Join(out, "app-worker.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "replicas: 2\n") {
+		t.Errorf("expected 2 replicas of worker, got:\n%s", b)
+	}
+	if !strings.Contains(string(b), "kind: Service\n") {
+		t.Errorf("expected a Service for worker, got:\n%s", b)
+	}
+
+	// $ in the command of a proc with argv is not expanded by kubernetes.
+	cfg.Procfile = filepath.Join(t.TempDir(), "Procfile.yml")
+	if err := os.WriteFile(cfg.Procfile, []byte("web:\n  command: [echo, \"$(HOME)\"]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := export(cfg, "kubernetes", out); err != nil {
+		t.Fatal(err)
+	}
+	b, err = os.ReadFile(filepath.Join(out, "app-web.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `command: ["echo", "$$(HOME)"]`; !strings.Contains(string(b), want) {
+		t.Errorf("expected %s, got:\n%s", want, b)
+	}
+
+	cfg.Export.Image = ""
+	if err := export(cfg, "kubernetes", out); err == nil {
+		t.Error("expected an error without an image")
+	}
+}
+
+func TestParseFormation(t *testing.T) {
+	formation, err := parseFormation("all=2, web=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config{Formation: formation}
+	if n := cfg.instances("web"); n != 3 {
+		t.Errorf("web: want 3, got %d", n)
+	}
+	if n := cfg.instances("worker"); n != 2 {
+		t.Errorf("worker: want 2, got %d", n)
+	}
+	if _, err := parseFormation("web"); err == nil {
+		t.Error("expected an error for an entry without a count")
+	}
+}
+
+func TestExportUpstart(t *testing.T) {
+	// upstart has no way to write a line break in a value.
+	env := strings.Replace(goldenEnv, "MOTD=\"first line\\nsecond line\"\n", "", 1)
+	testGolden(t, writeExportProcfile(t, goldenProcfile, env), "upstart")
+
+	err := export(writeExportProcfile(t, goldenProcfile, goldenEnv), "upstart", t.TempDir())
+	if want := "web: MOTD: upstart cannot quote a line break"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("error does not contain %q: %v", want, err)
+	}
+}
+
+func TestExportKubernetesGolden(t *testing.T) {
+	cfg := writeExportProcfile(t, goldenProcfile, goldenEnv)
+	cfg.Export.Image = "example/app:1"
+	// kubernetes would expand $(HOME) in the value.
+	cfg.Procs = map[string]*procConfig{"worker": {Env: map[string]string{"CACHE": "$(HOME)/cache"}}}
+	testGolden(t, cfg, "kubernetes")
+}
+
+func TestExportLaunchd(t *testing.T) {
+	testGolden(t, writeExportProcfile(t, goldenProcfile, goldenEnv), "launchd")
+}
+
+func TestExportOpenRC(t *testing.T) {
+	testGolden(t, writeExportProcfile(t, goldenProcfile, goldenEnv), "openrc")
+}
+
+func TestExportTemplate(t *testing.T) {
+	cfg := writeExportProcfile(t, goldenProcfile, goldenEnv)
+	cfg.Export.Template = filepath.Join("testdata", "templates", "custom")
+	testGolden(t, cfg, "custom")
+
+	cfg.Export.Template = ""
+	if err := export(cfg, "custom", t.TempDir()); err == nil {
+		t.Error("expected an error without a template directory")
+	}
+}
+
+func TestExportArgv(t *testing.T) {
+	procfile := filepath.Join(t.TempDir(), "Procfile.yml")
+	if err := os.WriteFile(procfile, []byte("web:\n  command: [./web, \"$HOME\", a b]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config{Procfile: procfile, BasePort: 5000}
+	cfg.Export.DryRun = true
+	for format, want := range map[string]string{
+		"upstart": "exec ./web '$HOME' 'a b' >> '/var/log/app/web.log' 2>&1\n",
+		"openrc":  "command=./web\ncommand_args=''\\''$HOME'\\'' '\\''a b'\\'''\n",
+	} {
+		got := captureStdout(t, func() error { return export(cfg, format, t.TempDir()) })
+		if !strings.Contains(got, want) {
+			t.Errorf("%s: output does not contain %q:\n%s", format, want, got)
+		}
+	}
+}
+
+func TestExportBadEnv(t *testing.T) {
+	cfg := writeExportProcfile(t, "web: ./web\n", "GOOD=1\nnot a variable\n")
+	err := export(cfg, "upstart", t.TempDir())
+	if want := ".env:2: expected KEY=VALUE"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("error does not contain %q: %v", want, err)
+	}
+}
+
+func TestExportEnvFiles(t *testing.T) {
+	cfg := writeExportProcfile(t, "web: ./web\n", "A=1\n")
+	dir := filepath.Dir(cfg.Procfile)
+	if err := os.WriteFile(filepath.Join(dir, ".env.ci"), []byte("B=\"it's $HOME and $A\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Profile = "ci"
+	cfg.Profiles = map[string]*profile{"ci": {EnvFiles: []string{filepath.Join(dir, ".env.ci")}}}
+	cfg.Export.DryRun = true
+	got := captureStdout(t, func() error { return export(cfg, "openrc", t.TempDir()) })
+	for _, want := range []string{"export A='1'\n", "export B='it'\\''s ${HOME} and 1'\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output does not contain %q:\n%s", want, got)
+		}
+	}
+}
+
+// captureStdout returns what f writes to os.Stdout.
+func captureStdout(t *testing.T, f func() error) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- b
+	}()
+	ferr := f()
+	w.Close()
+	b := <-done
+	if ferr != nil {
+		t.Fatal(ferr)
+	}
+	return string(b)
+}
+
+func TestExportOptions(t *testing.T) {
+	cfg := writeExportProcfile(t, "web: ./web\n", "")
+	cfg.Export = exportOptions{App: "billing", User: "www", LogDir: "/srv/log", WorkDir: "/srv/billing"}
+	out := filepath.Join(t.TempDir(), "out")
+
+	cfg.Export.DryRun = true
+	got := captureStdout(t, func() error { return export(cfg, "upstart", out) })
+	for _, want := range []string{
+		"# " + filepath.Join(out, "billing-web.conf") + "\n",
+		"start on starting billing-web\n",
+		"setuid www\n",
+		"chdir /srv/billing\n",
+		"'\\''/srv/log/web.log'\\''",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("dry run output does not contain %q:\n%s", want, got)
+		}
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("dry run should not create %s", out)
+	}
+
+	cfg.Export.DryRun = false
+	if err := export(cfg, "upstart", out); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Export.Diff = true
+	if got := captureStdout(t, func() error { return export(cfg, "upstart", out) }); got != "" {
+		t.Errorf("expected no differences, got:\n%s", got)
+	}
+	cfg.Export.User = "nobody"
+	got = captureStdout(t, func() error { return export(cfg, "upstart", out) })
+	if !strings.Contains(got, "-setuid www\n+setuid nobody\n") {
+		t.Errorf("expected setuid to differ, got:\n%s", got)
+	}
+
+	// a proc removed from the Procfile leaves a stale file.
+	stale := filepath.Join(out, "billing-worker.conf")
+	if err := os.WriteFile(stale, []byte("respawn\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(out, "other-web.conf"), []byte("respawn\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got = captureStdout(t, func() error { return export(cfg, "upstart", out) })
+	if want := "--- " + stale + "\n+++ " + os.DevNull + "\n@@ -1,1 +0,0 @@\n-respawn\n"; !strings.Contains(got, want) {
+		t.Errorf("expected %s to be removed, got:\n%s", stale, got)
+	}
+	if strings.Contains(got, "other-web.conf") {
+		t.Errorf("expected the files of other apps to be left alone, got:\n%s", got)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n"
+	b := "1\n2\n3\n4\nfive\n6\n7\n8\n9\n10\n11\n"
+	want := `--- a
++++ b
+@@ -2,9 +2,10 @@
+ 2
+ 3
+ 4
+-5
++five
+ 6
+ 7
+ 8
+ 9
+ 10
++11
+`
+	if got := unifiedDiff("a", "b", a, b); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+	if got := unifiedDiff("a", "b", a, a); got != "" {
+		t.Errorf("expected no differences, got:\n%s", got)
+	}
+}
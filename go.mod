@@ -3,7 +3,6 @@ module github.com/mattn/goreman
 go 1.25.0
 
 require (
-	github.com/joho/godotenv v1.5.1
 	github.com/mattn/go-colorable v0.1.15
 	golang.org/x/sys v0.46.0
 	gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"io"
+	"net/rpc"
+	"strings"
+)
+
+// errOldRPCClient is the error of a call from a goreman older than RPCArgs,
+// which sends the proc names without a token.
+var errOldRPCClient = errors.New("this goreman run is older than the server, which needs a token with every call: upgrade it")
+
+// errOldRPCServer is the error of a call to a goreman older than RPCArgs.
+var errOldRPCServer = errors.New("the goreman server is older than this goreman run and takes no token: restart it with this version")
+
+// gobCodec is the gob server codec of net/rpc, which reports the arguments of
+// an older client with errOldRPCClient instead of a gob type mismatch.
+type gobCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+}
+
+func newGobCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	buf := bufio.NewWriter(conn)
+	return &gobCodec{
+		rwc:    conn,
+		dec:    gob.NewDecoder(conn),
+		enc:    gob.NewEncoder(buf),
+		encBuf: buf,
+	}
+}
+
+func (c *gobCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobCodec) ReadRequestBody(body any) error {
+	err := c.dec.Decode(body)
+	// gob reads the whole value before the mismatch, so the stream can be
+	// read further.
+	if _, ok := body.(*RPCArgs); ok && err != nil && strings.HasPrefix(err.Error(), "gob: type mismatch") {
+		return errOldRPCClient
+	}
+	return err
+}
+
+func (c *gobCodec) WriteResponse(r *rpc.Response, body any) error {
+	err := c.enc.Encode(r)
+	if err == nil {
+		err = c.enc.Encode(body)
+	}
+	if err != nil {
+		// the stream is broken.
+		c.Close()
+		return err
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *gobCodec) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
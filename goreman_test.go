@@ -2,12 +2,16 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
+	"net/rpc"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 )
@@ -15,6 +19,17 @@ import (
 var sleep string
 
 func TestMain(m *testing.M) {
+	// act as a leftover process holding a port for TestKillStale.
+	if addr := os.Getenv("GOREMAN_TEST_LISTEN"); addr != "" {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			panic(err)
+		}
+		defer l.Close()
+		time.Sleep(time.Hour)
+		os.Exit(1)
+	}
+
 	var dir string
 	var err error
 	sleep, err = exec.LookPath("sleep")
@@ -50,6 +65,13 @@ func TestMain(m *testing.M) {
 	os.Exit(r)
 }
 
+// testSocket returns an RPC socket path in a temporary directory, so that
+// tests do not listen in the package directory.
+func testSocket(t *testing.T) string {
+	t.Helper()
+	return filepath.Join(t.TempDir(), socketFile)
+}
+
 func startGoreman(ctx context.Context, t *testing.T, ch <-chan os.Signal, file []byte) error {
 	t.Helper()
 	f, err := os.CreateTemp("", "")
@@ -62,6 +84,7 @@ func startGoreman(ctx context.Context, t *testing.T, ch <-chan os.Signal, file [
 	cfg := &config{
 		ExitOnError: true,
 		Procfile:    f.Name(),
+		Socket:      testSocket(t),
 	}
 	if ch == nil {
 		ch = notifyCh()
@@ -152,7 +175,7 @@ web1: sleep 0.2
 			return
 		default:
 			var ret string
-			if err := gm.Status(nil, &ret); err != nil {
+			if err := gm.Status(RPCArgs{}, &ret); err != nil {
 				t.Error(err)
 			}
 			time.Sleep(time.Millisecond)
@@ -172,6 +195,8 @@ func TestGoremanReleasesRPCPort(t *testing.T) {
 		ExitOnError: true,
 		Procfile:    f.Name(),
 		Port:        18555,
+		Socket:      testSocket(t),
+		origins:     map[string]string{"port": "-p"},
 	}
 	if err := start(context.TODO(), notifyCh(), cfg); err != nil {
 		t.Fatal(err)
@@ -200,6 +225,7 @@ func TestGoremanRestartAllKeepsSupervisorAlive(t *testing.T) {
 	cfg := &config{
 		Procfile: f.Name(),
 		Port:     18556,
+		Socket:   testSocket(t),
 	}
 	sc := make(chan os.Signal, 1)
 	done := make(chan struct{}, 1)
@@ -208,7 +234,7 @@ func TestGoremanRestartAllKeepsSupervisorAlive(t *testing.T) {
 		done <- struct{}{}
 	}()
 	for i := 0; ; i++ {
-		if err = run("restart-all", nil, cfg.Port); err == nil {
+		if err = run(cfg, "restart-all", nil); err == nil {
 			break
 		}
 		if i > 100 {
@@ -225,6 +251,300 @@ func TestGoremanRestartAllKeepsSupervisorAlive(t *testing.T) {
 	<-done
 }
 
+func TestGoremanRPCSocket(t *testing.T) {
+	dir := t.TempDir()
+	procfile := filepath.Join(dir, "Procfile")
+	if err := os.WriteFile(procfile, []byte("web1: sleep 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config{
+		Procfile: procfile,
+		Port:     18557,
+		Socket:   filepath.Join(dir, socketFile),
+		origins:  map[string]string{"port": "default"},
+	}
+	// a socket left behind by a goreman which was killed.
+	l, err := net.Listen("unix", cfg.Socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+
+	sc := make(chan os.Signal, 1)
+	done := make(chan struct{}, 1)
+	go func() {
+		start(context.TODO(), sc, cfg)
+		done <- struct{}{}
+	}()
+	for i := 0; ; i++ {
+		client, err := rpc.Dial("unix", cfg.Socket)
+		if err == nil {
+			client.Close()
+			break
+		}
+		if i > 100 {
+			t.Fatalf("could not reach RPC socket: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if fi, err := os.Stat(cfg.Socket); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("socket: want mode 0600, got %v, %v", fi.Mode(), err)
+	}
+	if _, err := listenUnix(cfg.Socket); err == nil {
+		t.Error("listening on the socket of a running goreman should fail")
+	}
+
+	// run finds the socket even when the TCP port is wrong.
+	runCfg := &config{Port: 1, Socket: cfg.Socket, origins: map[string]string{"port": "default"}}
+	if err := run(runCfg, "status", nil); err != nil {
+		t.Fatal(err)
+	}
+	runCfg.origins["port"] = "-p"
+	if err := run(runCfg, "status", nil); err == nil {
+		t.Error("run with an explicit port should not use the socket")
+	}
+
+	tokenFile := strings.TrimSuffix(cfg.Socket, ".sock") + ".token"
+	if fi, err := os.Stat(tokenFile); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("token: want mode 0600, got %v, %v", fi.Mode(), err)
+	}
+	client, err := rpc.Dial("unix", cfg.Socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ret string
+	for _, token := range []string{"", "wrong"} {
+		err := client.Call("Goreman.Status", RPCArgs{Token: token}, &ret)
+		if err == nil || err.Error() != errRPCToken.Error() {
+			t.Errorf("token %q: want %v, got %v", token, errRPCToken, err)
+		}
+	}
+	// the arguments of a goreman older than RPCArgs.
+	if err := client.Call("Goreman.Status", []string{"web1"}, &ret); err == nil || err.Error() != errOldRPCClient.Error() {
+		t.Errorf("old arguments: want %v, got %v", errOldRPCClient, err)
+	}
+	client.Close()
+
+	sc <- os.Interrupt
+	<-done
+	for _, file := range []string{cfg.Socket, tokenFile} {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed: %v", file, err)
+		}
+	}
+}
+
+// oldGoreman has the methods of a goreman server older than RPCArgs.
+type oldGoreman struct{}
+
+func (oldGoreman) Status(args []string, ret *string) error {
+	return nil
+}
+
+func TestRunOldRPCServer(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("Goreman", oldGoreman{}); err != nil {
+		t.Fatal(err)
+	}
+	socket := testSocket(t)
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go server.Accept(l)
+
+	cfg := &config{Socket: socket, origins: map[string]string{"port": "default"}}
+	if err := run(cfg, "status", nil); err != errOldRPCServer {
+		t.Errorf("want %v, got %v", errOldRPCServer, err)
+	}
+}
+
+func TestGoremanRPCTLS(t *testing.T) {
+	dir := t.TempDir()
+	procfile := filepath.Join(dir, "Procfile")
+	if err := os.WriteFile(procfile, []byte("web1: sleep 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOREMAN_RPC_TOKEN", "secret")
+	cfg := &config{
+		Procfile: procfile,
+		Port:     18558,
+		Socket:   filepath.Join(dir, socketFile),
+		TLS:      true,
+		origins:  map[string]string{"port": "-p"},
+	}
+	sc := make(chan os.Signal, 1)
+	done := make(chan struct{}, 1)
+	go func() {
+		start(context.TODO(), sc, cfg)
+		done <- struct{}{}
+	}()
+	for i := 0; ; i++ {
+		err := run(cfg, "status", nil)
+		if err == nil {
+			break
+		}
+		if i > 100 {
+			t.Fatalf("could not reach RPC server over TLS: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".goreman.token")); !os.IsNotExist(err) {
+		t.Errorf("token file written although GOREMAN_RPC_TOKEN is set: %v", err)
+	}
+	// the certificates are kept next to the socket.
+	if _, err := os.Stat(filepath.Join(dir, ".goreman.tls", "ca.pem")); err != nil {
+		t.Errorf("certificates are not next to the socket: %v", err)
+	}
+
+	// a client without a certificate is refused.
+	tc, err := clientTLSConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.Certificates = nil
+	if conn, err := tls.Dial("tcp", defaultServer(cfg.Port), tc); err == nil {
+		var ret string
+		err = rpc.NewClient(conn).Call("Goreman.Status", RPCArgs{Token: "secret"}, &ret)
+		if err == nil {
+			t.Error("call without a client certificate should fail")
+		}
+		conn.Close()
+	}
+	sc <- os.Interrupt
+	<-done
+}
+
+func TestRPCSocketPath(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	if path, err := rpcSocketPath(&config{}); err != nil || path != socketFile {
+		t.Errorf("want %s, got %s, %v", socketFile, path, err)
+	}
+	dir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", dir)
+	path, err := rpcSocketPath(&config{})
+	if err != nil || filepath.Dir(path) != dir || !strings.HasPrefix(filepath.Base(path), "goreman-") {
+		t.Errorf("want a socket in %s, got %s, %v", dir, path, err)
+	}
+	if path2, _ := rpcSocketPath(&config{}); path2 != path {
+		t.Errorf("socket path is not stable: %s, %s", path, path2)
+	}
+}
+
+func TestGoremanIdleRPCConnsDontDelayExit(t *testing.T) {
+	dir := t.TempDir()
+	procfile := filepath.Join(dir, "Procfile")
+	if err := os.WriteFile(procfile, []byte("web1: sleep 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOREMAN_RPC_TOKEN", "secret")
+	cfg := &config{Procfile: procfile, Socket: filepath.Join(dir, socketFile)}
+	sc := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- start(context.TODO(), sc, cfg)
+	}()
+	dial := func() net.Conn {
+		for i := 0; ; i++ {
+			conn, err := net.Dial("unix", cfg.Socket)
+			if err == nil {
+				return conn
+			}
+			if i > 100 {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	// a connection which sends nothing, and an idle JSON-RPC one.
+	silent := dial()
+	defer silent.Close()
+	idle := dial()
+	defer idle.Close()
+	if _, err := idle.Write([]byte(`{"jsonrpc": "2.0", "method": "List", "params": {"token": "secret"}, "id": 1}` + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idle.Read(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	sc <- os.Interrupt
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("goreman did not exit")
+	}
+	if d := time.Since(now); d > 2*time.Second {
+		t.Errorf("exit took %s", d)
+	}
+}
+
+func TestGoremanRPCPortInUse(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	dir := t.TempDir()
+	procfile := filepath.Join(dir, "Procfile")
+	if err := os.WriteFile(procfile, []byte("web1: sleep 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config{
+		Procfile: procfile,
+		Port:     uint(l.Addr().(*net.TCPAddr).Port),
+		Socket:   filepath.Join(dir, socketFile),
+		origins:  map[string]string{"port": "$GOREMAN_RPC_PORT"},
+	}
+	err = start(context.TODO(), notifyCh(), cfg)
+	want := fmt.Sprintf("RPC port %d is already in use", cfg.Port)
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("want %q, got %v", want, err)
+	}
+	if _, err := os.Stat(cfg.Socket); !os.IsNotExist(err) {
+		t.Errorf("socket was not removed: %v", err)
+	}
+}
+
+func TestRPCListeners(t *testing.T) {
+	t.Setenv("GOREMAN_RPC_ADDR", "")
+	os.Unsetenv("GOREMAN_RPC_ADDR")
+	dir := t.TempDir()
+	socket, listenSocket := filepath.Join(dir, "g.sock"), filepath.Join(dir, "a.sock")
+	cfg := &config{Port: 8555, Socket: socket, origins: map[string]string{"port": "default"}}
+	addrs, err := rpcListeners(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// projects started with the defaults do not compete for the port.
+	if want := []rpcListener{{"unix", socket}}; !slices.Equal(addrs, want) {
+		t.Errorf("default: want %v, got %v", want, addrs)
+	}
+	cfg.origins["port"] = ".goreman:1"
+	if addrs, err = rpcListeners(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if want := []rpcListener{{"tcp", "127.0.0.1:8555"}, {"unix", socket}}; !slices.Equal(addrs, want) {
+		t.Errorf("port: want %v, got %v", want, addrs)
+	}
+	cfg.Listen = []string{"::1", "0.0.0.0:9000", "unix:" + listenSocket}
+	if addrs, err = rpcListeners(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if want := []rpcListener{{"tcp", "[::1]:8555"}, {"tcp", "0.0.0.0:9000"}, {"unix", listenSocket}}; !slices.Equal(addrs, want) {
+		t.Errorf("listen: want %v, got %v", want, addrs)
+	}
+	if path, _ := rpcSocketPath(cfg); path != listenSocket {
+		t.Errorf("socket: want the listen socket, got %s", path)
+	}
+}
+
 func TestGoremanRestartDoesntLeakGoroutines(t *testing.T) {
 	var file = []byte(`
 web1: sleep 10
@@ -312,4 +632,151 @@ web4: sleep 10
 	case <-time.After(30 * time.Millisecond):
 	}
 	sc <- os.Interrupt
+	// wait until the procs are stopped, or goreman would still be stopping
+	// them while the next test loads its own into procs.
+	<-goremanStopped
+}
+
+func startGoremanWithProcs(t *testing.T, file []byte, pcs map[string]*procConfig) error {
+	t.Helper()
+	f, err := os.CreateTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(file); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config{
+		ExitOnError: true,
+		Procfile:    f.Name(),
+		Procs:       pcs,
+		Socket:      testSocket(t),
+	}
+	return start(context.TODO(), notifyCh(), cfg)
+}
+
+func TestGoremanProcConfig(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "marker"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var file = []byte(`
+web1: test "$FOO" = bar && test "$PORT" = 6000 && test -f marker
+web2: exit 1
+`)
+	off := false
+	err := startGoremanWithProcs(t, file, map[string]*procConfig{
+		"web1": {Cwd: dir, Env: map[string]string{"FOO": "bar"}, Port: 6000},
+		"web2": {Autostart: &off},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGoremanExitOnErrorStopSignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+	dir := t.TempDir()
+	var file = []byte(`
+web1: trap 'touch stopped; exit 0' TERM; while :; do sleep 0.01; done
+web2: sleep 0.3; exit 1
+`)
+	err := startGoremanWithProcs(t, file, map[string]*procConfig{
+		"web1": {Cwd: dir, StopSignal: "TERM"},
+	})
+	if err == nil {
+		t.Fatal("expected the error of web2")
+	}
+	// web1 is stopped with its stop signal, not os.Interrupt.
+	if _, err := os.Stat(filepath.Join(dir, "stopped")); err != nil {
+		t.Errorf("web1 did not get SIGTERM: %v", err)
+	}
+}
+
+func TestGoremanArgv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses test")
+	}
+	path := filepath.Join(t.TempDir(), "Procfile.yml")
+	// goreman expands $PORT, and without a shell "$PORT a" is one argument.
+	err := os.WriteFile(path, []byte(`web:
+  command: [test, "$PORT a", =, "5000 a"]
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config{ExitOnError: true, Procfile: path, BasePort: 5000, Socket: testSocket(t)}
+	if err := start(context.TODO(), notifyCh(), cfg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGoremanFormationIsExportOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Procfile")
+	if err := os.WriteFile(path, []byte("web: sleep 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config{
+		Procfile:  path,
+		Formation: map[string]int{"web": 2},
+		Socket:    testSocket(t),
+		origins:   map[string]string{"formation": "-m"},
+	}
+	err := start(context.TODO(), notifyCh(), cfg)
+	if want := "-m is only used by goreman export"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("error does not contain %q: %v", want, err)
+	}
+}
+
+func TestGoremanRestartOnFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+	defer func(d time.Duration) { restartDelay = d }(restartDelay)
+	restartDelay = 10 * time.Millisecond
+	dir := t.TempDir()
+	var file = []byte(`
+web1: test -f marker || { touch marker; exit 1; }
+`)
+	f, err := os.CreateTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(file); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config{
+		Procfile: f.Name(),
+		Procs:    map[string]*procConfig{"web1": {Cwd: dir, Restart: restartOnFailure}},
+		Socket:   testSocket(t),
+	}
+	if err := start(context.TODO(), notifyCh(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "marker")); err != nil {
+		t.Fatal("web1 did not run twice:", err)
+	}
+}
+
+func TestGoremanDependsOn(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+	dir := t.TempDir()
+	var file = []byte(`
+web1: sleep 0.1 && touch started && sleep 0.3
+web2: test -f started
+`)
+	err := startGoremanWithProcs(t, file, map[string]*procConfig{
+		"web1": {Cwd: dir, HealthCheck: &healthCheck{Command: "test -f started", Interval: 10 * time.Millisecond}},
+		"web2": {Cwd: dir, DependsOn: []string{"web1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// healthCheck describes how to check that a proc is ready.
+type healthCheck struct {
+	// Path (e.g. /health) requested on the proc's PORT, or a full URL.
+	// Any 2xx or 3xx response is healthy.
+	HTTP string `yaml:"http,omitempty"`
+	// Shell command which exits with zero when the proc is healthy.
+	Command string `yaml:"command,omitempty"`
+	// Time between checks. Defaults to 1s.
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// Time after which a check fails. Defaults to the interval.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+func (hc *healthCheck) interval() time.Duration {
+	if hc.Interval > 0 {
+		return hc.Interval
+	}
+	return time.Second
+}
+
+func (hc *healthCheck) timeout() time.Duration {
+	if hc.Timeout > 0 {
+		return hc.Timeout
+	}
+	return hc.interval()
+}
+
+// url returns the URL requested by an HTTP check of proc.
+func (hc *healthCheck) url(proc *procInfo) string {
+	if strings.Contains(hc.HTTP, "://") {
+		return hc.HTTP
+	}
+	path := hc.HTTP
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return fmt.Sprintf("http://127.0.0.1:%d%s", proc.port, path)
+}
+
+// check runs the health check of proc once.
+func (hc *healthCheck) check(proc *procInfo, env []string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), hc.timeout())
+	defer cancel()
+
+	if hc.HTTP != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, hc.url(proc), nil)
+		if err != nil {
+			return false
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return false
+		}
+	}
+	if hc.Command != "" {
+		cs := append(cmdStart, hc.Command)
+		cmd := exec.CommandContext(ctx, cs[0], cs[1:]...)
+		cmd.Dir = proc.dir
+		cmd.Env = env
+		if cmd.Run() != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// watchHealth checks the health of proc until done is closed.
+func watchHealth(proc *procInfo, env []string, done <-chan struct{}) {
+	hc := proc.healthCheck
+	ticker := time.NewTicker(hc.interval())
+	defer ticker.Stop()
+	for {
+		healthy := hc.check(proc, env)
+		if healthy != proc.healthy.Load() {
+			proc.healthy.Store(healthy)
+			if healthy {
+				fmt.Fprintf(proc.logger, "%s is healthy\n", proc.name)
+			} else {
+				fmt.Fprintf(proc.logger, "%s is unhealthy\n", proc.name)
+			}
+		}
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ready reports whether proc is running and, if it has a health check,
+// healthy.
+func (proc *procInfo) ready() bool {
+	proc.mu.Lock()
+	running := proc.cmd != nil
+	proc.mu.Unlock()
+	if !running {
+		return false
+	}
+	return proc.healthCheck == nil || proc.healthy.Load()
+}
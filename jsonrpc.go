@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/rpc"
+	"strings"
+	"sync"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	jsonrpcParseError     = -32700
+	jsonrpcInvalidRequest = -32600
+	jsonrpcMethodNotFound = -32601
+	jsonrpcInvalidParams  = -32602
+	// errors returned by the methods, e.g. an invalid token.
+	jsonrpcServerError = -32000
+)
+
+type jsonrpcRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	// empty for a notification, which gets no response.
+	ID json.RawMessage `json:"id"`
+}
+
+type jsonrpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+}
+
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// jsonrpcCodec is a net/rpc server codec for JSON-RPC 2.0. Params are
+// either the argument object or an array holding it, and a method without a
+// service name is a method of Goreman. Batches are not supported.
+type jsonrpcCodec struct {
+	dec *json.Decoder
+	c   io.Closer
+	req jsonrpcRequest
+
+	mu      sync.Mutex // guards enc, seq and pending
+	enc     *json.Encoder
+	seq     uint64
+	pending map[uint64]*jsonrpcPending
+}
+
+// jsonrpcPending is a request which has not been responded to yet.
+type jsonrpcPending struct {
+	id  json.RawMessage
+	err *jsonrpcError // set if the request is invalid
+}
+
+func newJSONRPCCodec(conn io.ReadWriteCloser) rpc.ServerCodec {
+	return &jsonrpcCodec{
+		dec:     json.NewDecoder(conn),
+		enc:     json.NewEncoder(conn),
+		c:       conn,
+		pending: map[uint64]*jsonrpcPending{},
+	}
+}
+
+func (c *jsonrpcCodec) ReadRequestHeader(r *rpc.Request) error {
+	c.req = jsonrpcRequest{}
+	if err := c.dec.Decode(&c.req); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+			// the stream cannot be read any further.
+			c.mu.Lock()
+			c.enc.Encode(jsonrpcResponse{
+				Version: "2.0",
+				ID:      json.RawMessage("null"),
+				Error:   &jsonrpcError{jsonrpcParseError, "parse error: " + err.Error()},
+			})
+			c.mu.Unlock()
+		}
+		return err
+	}
+
+	p := &jsonrpcPending{id: c.req.ID}
+	params := bytes.TrimSpace(c.req.Params)
+	switch {
+	case c.req.Version != "2.0":
+		p.err = &jsonrpcError{jsonrpcInvalidRequest, `invalid request: jsonrpc must be "2.0"`}
+	case c.req.Method == "":
+		p.err = &jsonrpcError{jsonrpcInvalidRequest, "invalid request: no method"}
+	case len(params) > 0 && params[0] != '{' && params[0] != '[':
+		p.err = &jsonrpcError{jsonrpcInvalidRequest, "invalid request: params must be an object or an array"}
+	}
+	if p.err != nil && len(p.id) == 0 {
+		p.id = json.RawMessage("null")
+	}
+	r.ServiceMethod = c.req.Method
+	if p.err != nil {
+		// net/rpc responds to an ill-formed method with an error.
+		r.ServiceMethod = ""
+	} else if !strings.Contains(r.ServiceMethod, ".") {
+		r.ServiceMethod = "Goreman." + r.ServiceMethod
+	}
+
+	c.mu.Lock()
+	c.seq++
+	c.pending[c.seq] = p
+	r.Seq = c.seq
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *jsonrpcCodec) ReadRequestBody(x any) error {
+	if x == nil {
+		return nil
+	}
+	params := bytes.TrimSpace(c.req.Params)
+	var err error
+	switch {
+	case len(params) == 0:
+	case params[0] == '[':
+		args := [1]any{x}
+		err = json.Unmarshal(params, &args)
+	default:
+		err = json.Unmarshal(params, x)
+	}
+	if err != nil {
+		c.mu.Lock()
+		c.pending[c.seq].err = &jsonrpcError{jsonrpcInvalidParams, "invalid params: " + err.Error()}
+		c.mu.Unlock()
+	}
+	return err
+}
+
+func (c *jsonrpcCodec) WriteResponse(r *rpc.Response, x any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.pending[r.Seq]
+	if p == nil {
+		return errors.New("invalid sequence number in response")
+	}
+	delete(c.pending, r.Seq)
+	if len(p.id) == 0 {
+		// a notification.
+		return nil
+	}
+
+	res := jsonrpcResponse{Version: "2.0", ID: p.id}
+	switch {
+	case p.err != nil:
+		res.Error = p.err
+	case r.Error == "":
+		res.Result = x
+	case strings.HasPrefix(r.Error, "rpc: can't find"):
+		res.Error = &jsonrpcError{jsonrpcMethodNotFound, "method not found: " + r.ServiceMethod}
+	default:
+		res.Error = &jsonrpcError{jsonrpcServerError, r.Error}
+	}
+	return c.enc.Encode(res)
+}
+
+func (c *jsonrpcCodec) Close() error {
+	return c.c.Close()
+}
@@ -17,8 +17,12 @@ type clogger struct {
 	done    chan struct{}
 	timeout time.Duration // how long to wait before printing partial lines
 	buffers buffers       // partial lines awaiting printing
+	recent  []string      // last lines, for the proxy error page and the API
 }
 
+// number of lines kept in clogger.recent.
+const maxRecentLines = 100
+
 var colors = []int{
 	32, // green
 	36, // cyan
@@ -71,6 +75,10 @@ func (l *clogger) writeBuffers(line []byte) {
 	}
 	fmt.Fprintf(out, "\x1b[m")
 	l.buffers = append(l.buffers, line)
+	l.recent = append(l.recent, string(bytes.TrimRight(bytes.Join(l.buffers, nil), "\r\n")))
+	if len(l.recent) > maxRecentLines {
+		l.recent = l.recent[len(l.recent)-maxRecentLines:]
+	}
 	l.buffers.WriteTo(out)
 	l.buffers = l.buffers[0:0]
 	mutex.Unlock()
@@ -127,6 +135,13 @@ func (l *clogger) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
+// recentLines returns the last lines written to the logger.
+func (l *clogger) recentLines() []string {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return append([]string(nil), l.recent...)
+}
+
 // create logger instance.
 func createLogger(name string, colorIndex int) *clogger {
 	mutex.Lock()
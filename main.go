@@ -7,15 +7,12 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"regexp"
-	"runtime"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
-
-	"github.com/joho/godotenv"
-	"gopkg.in/yaml.v3"
+	"sync/atomic"
+	"time"
 )
 
 // version is the git tag at the time of build and is used to denote the
@@ -29,10 +26,19 @@ const (
 
 func usage() {
 	fmt.Fprint(os.Stderr, `Tasks:
-  goreman check                      # Show entries in Procfile
+  goreman check                      # Show entries in Procfile and
+                                       validate .goreman
   goreman help [TASK]                # Show this help
+  goreman config                     # Show the effective configuration
+  goreman env [-format FORMAT] [-diff] [PROC]
+                                     # Show the environment of the app, or
+                                       of PROC (dotenv, shell or json)
   goreman export [FORMAT] [LOCATION] # Export the apps to another process
-                                       (upstart)
+                                       (upstart, kubernetes, launchd, openrc,
+                                        custom with -template DIR)
+                                       see goreman export -h for options
+  goreman run-env [-p PROC] COMMAND  # Run a command in the environment of
+                                       the app, or of PROC
   goreman run COMMAND [PROCESS...]   # Run a command
                                        start
                                        stop
@@ -41,7 +47,10 @@ func usage() {
                                        restart-all
                                        list
                                        status
-  goreman start [PROCESS]            # Start the application
+                                       signal SIGNAL PROCESS...
+                                       logs PROCESS
+  goreman start [-profile NAME] [-kill-stale] [-proxy ADDR] [PROCESS...]
+                                     # Start the application
   goreman version                    # Display Goreman version
 
 Options:
@@ -54,11 +63,35 @@ Options:
 type procInfo struct {
 	name       string
 	cmdline    string
+	argv       []string // run without a shell if set
+	execArgs   []string // command to run, after interpolation
 	cmd        *exec.Cmd
 	port       uint
 	setPort    bool
+	fixedPort  bool        // port was set in .goreman
+	namedPorts []namedPort // additional ports, by name
+	source     string      // location in the Procfile
 	colorIndex int
 
+	// settings from the procs section of .goreman.
+	dir         string
+	env         map[string]string
+	secrets     map[string]bool // variables of env from secret references
+	environ     []string        // whole environment of the proc
+	stopSignal  os.Signal
+	stopTimeout time.Duration
+	restart     string
+	dependsOn   []string
+	healthCheck *healthCheck
+	autostart   bool
+
+	// result of the last health check.
+	healthy atomic.Bool
+
+	// True while a goroutine is about to spawn the proc, i.e. waiting for
+	// its dependencies or for a restart.
+	pending bool
+
 	// True if we called stopProc to kill the process, in which case an
 	// *os.ExitError is not the fault of the subprocess
 	stoppedBySupervisor bool
@@ -77,11 +110,24 @@ var mu sync.Mutex
 // process informations named with proc.
 var procs []*procInfo
 
-// filename of Procfile.
-var procfile = flag.String("f", "Procfile", "proc file")
+// filename of Procfile, and of overlays if repeated.
+var procfile = &procfileFlag{files: []string{"Procfile"}}
+
+func init() {
+	flag.Var(procfile, "f", "proc file, repeat to add overlays")
+}
 
 // rpc port number.
-var port = flag.Uint("p", defaultPort(), "port")
+var port = flag.Uint("p", 8555, "port")
+
+// path of the RPC Unix socket
+var socketOption = flag.String("rpc-socket", "", "path of the RPC Unix socket (default "+socketFile+", or under $XDG_RUNTIME_DIR)")
+
+// addresses of the RPC server
+var listenOption = flag.String("rpc-listen", "", "addresses of the RPC server, comma separated: HOST, HOST:PORT or unix:PATH (default "+defaultAddr()+" and the socket)")
+
+// true to require client certificates on the RPC port
+var rpcTLS = flag.Bool("rpc-tls", false, "use TLS with client certificates on the RPC port")
 
 var startRPCServer = flag.Bool("rpc-server", true, "Start an RPC server listening on "+defaultAddr())
 
@@ -104,75 +150,146 @@ var logTime = flag.Bool("logtime", true, "show timestamp in log")
 
 var envFileOption = flag.String("env", ".env", "Environment files to load, comma separated")
 
-var maxProcNameLength = 0
+// number of instances of each exported proc, e.g. all=1,web=2
+var formationOption = flag.String("m", "", "formation of exported procs, e.g. all=1,web=2")
+
+// profile to apply
+var profileOption = flag.String("profile", "", "profile from "+configFile+" to apply")
 
-var re = regexp.MustCompile(`\$([a-zA-Z_][a-zA-Z0-9_]*)`)
+// config file
+var configOption = flag.String("config", "", "config file (default "+configFile+")")
+
+var maxProcNameLength = 0
 
 type config struct {
 	Procfile string `yaml:"procfile"`
+	// Files overlaid on the Procfile, in order.
+	Overlays []string `yaml:"overlays,omitempty"`
 	// Port for RPC server
-	Port     uint   `yaml:"port"`
-	BaseDir  string `yaml:"basedir"`
-	BasePort uint   `yaml:"baseport"`
-	Args     []string
-	EnvFiles []string
+	Port     uint     `yaml:"port"`
+	BaseDir  string   `yaml:"basedir"`
+	BasePort uint     `yaml:"baseport"`
+	Args     []string `yaml:"-"`
+	EnvFiles []string `yaml:"envfiles"`
+	// Path of the Unix socket of the RPC server. Defaults to .goreman.sock,
+	// or a socket under $XDG_RUNTIME_DIR.
+	Socket string `yaml:"socket,omitempty"`
+	// Addresses of the RPC server: HOST, HOST:PORT or unix:PATH. Defaults
+	// to the RPC port on 127.0.0.1 and the socket.
+	Listen []string `yaml:"listen,omitempty"`
+	// If true, the RPC port uses TLS and requires client certificates,
+	// generated next to the socket.
+	TLS bool `yaml:"tls,omitempty"`
 	// If true, exit the supervisor process if a subprocess exits with an error.
 	ExitOnError bool `yaml:"exit_on_error"`
+	// Number of instances of each proc. The key "all" sets the default.
+	Formation map[string]int `yaml:"formation,omitempty"`
+	// Options of goreman export.
+	Export exportOptions `yaml:"export,omitempty"`
+	// Per-proc settings, keyed by proc name.
+	Procs map[string]*procConfig `yaml:"procs,omitempty"`
+	// Name of the profile to apply.
+	Profile string `yaml:"profile"`
+	// Named profiles.
+	Profiles map[string]*profile `yaml:"profiles,omitempty"`
+	// Reverse proxy to the procs.
+	Proxy proxyConfig `yaml:"proxy,omitempty"`
+	// If true, kill processes holding the ports of the procs on start.
+	KillStale bool `yaml:"-"`
+
+	// name of the config file and the line of each key in it.
+	file  string
+	lines map[string]int
+	// where each setting came from, keyed like lines.
+	origins map[string]string
+	// true once the profile has been merged, and the procs it selects.
+	profileApplied bool
+	selected       []string
+	// error from loading the config file, reported by validateConfig.
+	loadErr error
+	// variables from the env files.
+	env map[string]string
+	// resolved secret references, keyed by reference.
+	secrets map[string]string
 }
 
 func readConfig() *config {
-	var cfg config
-
 	flag.Parse()
 	if flag.NArg() == 0 {
 		usage()
 	}
 
-	cfg.Procfile = *procfile
-	cfg.Port = *port
-	cfg.BaseDir = *basedir
-	cfg.BasePort = *baseport
-	cfg.EnvFiles = strings.FieldsFunc(*envFileOption, func(char rune) bool { return char == ',' })
-	cfg.ExitOnError = *exitOnError
+	cfg, err := loadConfig(flag.CommandLine, os.LookupEnv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "goreman: %s\n", err.Error())
+		os.Exit(1)
+	}
 	cfg.Args = flag.Args()
+	return cfg
+}
+
+// parseFormation parses a formation such as "all=1,web=2".
+func parseFormation(s string) (map[string]int, error) {
+	formation := map[string]int{}
+	for _, entry := range strings.FieldsFunc(s, func(char rune) bool { return char == ',' }) {
+		tokens := strings.SplitN(entry, "=", 2)
+		if len(tokens) != 2 {
+			return nil, errors.New("invalid formation: " + entry)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(tokens[1]))
+		if err != nil || n < 0 {
+			return nil, errors.New("invalid formation: " + entry)
+		}
+		formation[strings.TrimSpace(tokens[0])] = n
+	}
+	return formation, nil
+}
 
-	b, err := os.ReadFile(".goreman")
-	if err == nil {
-		yaml.Unmarshal(b, &cfg)
+// instances returns the number of instances of the named proc.
+func (cfg *config) instances(name string) int {
+	if n, ok := cfg.Formation[name]; ok {
+		return n
+	}
+	if n, ok := cfg.Formation["all"]; ok {
+		return n
 	}
-	return &cfg
+	return 1
 }
 
+var errNoEntry = errors.New("no valid entry")
+
 // read Procfile and parse it.
 func readProcfile(cfg *config) error {
-	content, err := os.ReadFile(cfg.Procfile)
-	if err != nil {
-		return err
-	}
+	entries, err := readProcfiles(cfg.procfiles())
 	mu.Lock()
 	defer mu.Unlock()
 
 	procs = []*procInfo{}
 	index := 0
-	for _, line := range strings.Split(string(content), "\n") {
-		tokens := strings.SplitN(line, ":", 2)
-		if len(tokens) != 2 {
-			continue
-		}
-		k, v := strings.TrimSpace(tokens[0]), strings.TrimSpace(tokens[1])
-		if len(k) == 0 || k[0] == '#' {
+	port := cfg.BasePort
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, e := range entries {
+		if e.warning != "" {
+			fmt.Fprintf(os.Stderr, "goreman: %s:%d: %s\n", e.file, e.line, e.warning)
 			continue
 		}
-		if runtime.GOOS == "windows" {
-			v = re.ReplaceAllStringFunc(v, func(s string) string {
-				return "%" + s[1:] + "%"
-			})
+		k, v := e.name, e.command
+		proc := &procInfo{name: k, cmdline: v, argv: e.argv, colorIndex: index, stopTimeout: 10 * time.Second, restart: restartNo, autostart: true}
+		// the block of ports of the proc starts at port.
+		proc.port = port
+		proc.setPort = *setPorts
+		port += 100
+		if pc := cfg.Procs[k]; pc != nil {
+			if err := pc.apply(cfg, proc); err != nil {
+				errs = append(errs, err)
+			}
 		}
-		proc := &procInfo{name: k, cmdline: v, colorIndex: index}
-		if *setPorts {
-			proc.setPort = true
-			proc.port = cfg.BasePort
-			cfg.BasePort += 100
+		proc.source = fmt.Sprintf("%s:%d", e.file, e.line)
+		if err := proc.resolve(cfg); err != nil {
+			errs = append(errs, err)
 		}
 		proc.cond = sync.NewCond(&proc.mu)
 		procs = append(procs, proc)
@@ -181,10 +298,10 @@ func readProcfile(cfg *config) error {
 		}
 		index = (index + 1) % len(colors)
 	}
-	if len(procs) == 0 {
-		return errors.New("no valid entry")
+	if len(procs) == 0 && len(errs) == 0 {
+		return errNoEntry
 	}
-	return nil
+	return errors.Join(errs...)
 }
 
 func defaultServer(serverPort uint) string {
@@ -198,24 +315,12 @@ func defaultAddr() string {
 	if s, ok := os.LookupEnv("GOREMAN_RPC_ADDR"); ok {
 		return s
 	}
-	return "0.0.0.0"
-}
-
-// default port
-func defaultPort() uint {
-	s := os.Getenv("GOREMAN_RPC_PORT")
-	if s != "" {
-		i, err := strconv.ParseUint(s, 10, 16)
-		if err == nil {
-			return uint(i)
-		}
-	}
-	return 8555
+	return "127.0.0.1"
 }
 
-// command: check. show Procfile entries.
+// command: check. show Procfile entries and validate the config.
 func check(cfg *config) error {
-	err := readProcfile(cfg)
+	err := loadProcs(cfg)
 	if err != nil {
 		return err
 	}
@@ -248,7 +353,11 @@ func findProc(name string) *procInfo {
 
 // command: start. spawn procs.
 func start(ctx context.Context, sig <-chan os.Signal, cfg *config) error {
-	err := readProcfile(cfg)
+	// the formation only sets the instances of exported services.
+	if cfg.origins["formation"] == "-m" {
+		return errors.New("-m is only used by goreman export, start runs one instance of each proc")
+	}
+	err := loadProcs(cfg)
 	if err != nil {
 		return err
 	}
@@ -258,31 +367,55 @@ func start(ctx context.Context, sig <-chan os.Signal, cfg *config) error {
 	// context anyway in case of early return.
 	defer cancel()
 	if len(cfg.Args) > 1 {
-		tmp := make([]*procInfo, 0, len(cfg.Args[1:]))
-		maxProcNameLength = 0
-		for _, v := range cfg.Args[1:] {
-			proc := findProc(v)
-			if proc == nil {
-				return errors.New("unknown proc: " + v)
-			}
-			tmp = append(tmp, proc)
-			if len(v) > maxProcNameLength {
-				maxProcNameLength = len(v)
-			}
+		if err := selectProcs(cfg.Args[1:]); err != nil {
+			return err
 		}
-		mu.Lock()
-		procs = tmp
-		mu.Unlock()
 	}
-	if len(cfg.EnvFiles) > 0 {
-		godotenv.Load(cfg.EnvFiles...)
+	if err := allocatePorts(cfg); err != nil {
+		return err
+	}
+	if cfg.Proxy.Listen != "" {
+		if err := startProxy(ctx, &cfg.Proxy); err != nil {
+			return err
+		}
 	}
 	rpcChan := make(chan *rpcMessage, 10)
+	serverDone := make(chan struct{})
+	var serverErr error
 	if *startRPCServer {
-		go startServer(ctx, rpcChan, cfg.Port)
+		server, err := newRPCServer(cfg, rpcChan)
+		if err != nil {
+			return err
+		}
+		go func() {
+			serverErr = server.serve(ctx)
+			close(serverDone)
+		}()
+	} else {
+		close(serverDone)
 	}
 	procsErr := startProcs(sig, rpcChan, cfg.ExitOnError)
-	return procsErr
+	// wait for the server to answer pending calls and remove its socket.
+	cancel()
+	<-serverDone
+	return errors.Join(procsErr, serverErr)
+}
+
+// parseStartFlags parses the flags given after start.
+func parseStartFlags(cfg *config) {
+	fs := flag.NewFlagSet("start", flag.ExitOnError)
+	fs.StringVar(&cfg.Profile, "profile", cfg.Profile, "profile from "+configFile+" to apply")
+	fs.BoolVar(&cfg.KillStale, "kill-stale", false, "kill leftover processes holding the ports of the procs")
+	fs.StringVar(&cfg.Proxy.Listen, "proxy", cfg.Proxy.Listen, "address of a reverse proxy to the procs, e.g. 127.0.0.1:8080")
+	fs.Parse(cfg.Args[1:])
+	fs.Visit(func(f *flag.Flag) {
+		key := f.Name
+		if key == "proxy" {
+			key = "proxy.listen"
+		}
+		cfg.origins[key] = "-" + f.Name
+	})
+	cfg.Args = append(cfg.Args[:1], fs.Args()...)
 }
 
 func showVersion() {
@@ -306,23 +439,29 @@ func main() {
 	switch cmd {
 	case "check":
 		err = check(cfg)
+	case "config":
+		err = showConfig(cfg)
 	case "help":
 		usage()
 	case "run":
 		if len(cfg.Args) >= 2 {
 			cmd, args := cfg.Args[1], cfg.Args[2:]
-			err = run(cmd, args, cfg.Port)
+			err = run(cfg, cmd, args)
 		} else {
 			usage()
 		}
-	case "export":
-		if len(cfg.Args) == 3 {
-			format, path := cfg.Args[1], cfg.Args[2]
-			err = export(cfg, format, path)
-		} else {
-			usage()
+	case "run-env":
+		var code int
+		code, err = runEnvCommand(cfg, cfg.Args[1:])
+		if err == nil {
+			os.Exit(code)
 		}
+	case "env":
+		err = envCommand(cfg, cfg.Args[1:])
+	case "export":
+		err = exportCommand(cfg, cfg.Args[1:])
 	case "start":
+		parseStartFlags(cfg)
 		c := notifyCh()
 		err = start(context.Background(), c, cfg)
 	case "version":
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// namedPort is an additional port of a proc, set as PORT_<NAME>.
+type namedPort struct {
+	name  string
+	port  uint
+	fixed bool // set in .goreman rather than assigned
+}
+
+// procPort refers to a port of a proc.
+type procPort struct {
+	name  string // name of a named port, or "" for PORT
+	env   string // environment variable
+	port  *uint
+	fixed bool
+}
+
+// ports returns PORT, if it is set, and the named ports of the proc.
+func (proc *procInfo) ports() []procPort {
+	var ports []procPort
+	if proc.setPort {
+		ports = append(ports, procPort{env: "PORT", port: &proc.port, fixed: proc.fixedPort})
+	}
+	for i := range proc.namedPorts {
+		p := &proc.namedPorts[i]
+		ports = append(ports, procPort{name: p.name, env: portEnvName(p.name), port: &p.port, fixed: p.fixed})
+	}
+	return ports
+}
+
+// portEnvName returns the environment variable of the named port, e.g.
+// PORT_ADMIN for admin.
+func portEnvName(name string) string {
+	return "PORT_" + strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z':
+			return r - 'a' + 'A'
+		case 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// portFree reports whether nothing listens on port. Errors other than the
+// port being in use, such as missing permissions, do not count.
+func portFree(port uint) bool {
+	if port == 0 {
+		return true
+	}
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return !isAddrInUse(err)
+	}
+	l.Close()
+	return true
+}
+
+// inUse returns the error for a port in use, naming the process which
+// holds it if it can be found.
+func (p procPort) inUse() error {
+	msg := fmt.Sprintf("%s %d is already in use", p.env, *p.port)
+	if pid, cmdline, err := portOwner(*p.port); err == nil && pid != 0 {
+		msg += fmt.Sprintf(" by pid %d (%s)", pid, cmdline)
+	}
+	return errors.New(msg)
+}
+
+// checkPorts returns an error if a port of the proc is in use.
+func (proc *procInfo) checkPorts() error {
+	for _, p := range proc.ports() {
+		if !portFree(*p.port) {
+			return p.inUse()
+		}
+	}
+	return nil
+}
+
+// how long killStale waits for a process to exit after SIGTERM.
+var staleTimeout = 5 * time.Second
+
+// killStale kills the process listening on port, and waits until the port
+// is free. It returns the process killed.
+func killStale(port uint) (string, error) {
+	pid, cmdline, err := portOwner(port)
+	if err != nil {
+		return "", fmt.Errorf("cannot find the process listening on port %d: %w", port, err)
+	}
+	if pid == 0 {
+		return "", fmt.Errorf("cannot find the process listening on port %d", port)
+	}
+	if pid == os.Getpid() {
+		return "", fmt.Errorf("port %d is used by goreman itself", port)
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return "", err
+	}
+	desc := fmt.Sprintf("pid %d (%s)", pid, cmdline)
+	if err := p.Signal(syscall.SIGTERM); err != nil {
+		return "", fmt.Errorf("cannot kill %s: %w", desc, err)
+	}
+	for _, kill := range []bool{false, true} {
+		if kill {
+			p.Kill()
+		}
+		for deadline := time.Now().Add(staleTimeout); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+			if portFree(port) {
+				return desc, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("port %d is still in use after killing %s", port, desc)
+}
+
+// allocatePorts checks that the ports of the procs are free. With
+// cfg.KillStale, the process holding a port is killed. Otherwise an assigned
+// port which is in use is replaced by the next free port, and a port set in
+// .goreman which is in use is an error.
+func allocatePorts(cfg *config) error {
+	mu.Lock()
+	ps := make([]*procInfo, len(procs))
+	copy(ps, procs)
+	mu.Unlock()
+
+	taken := map[uint]bool{cfg.Port: true}
+	for _, proc := range ps {
+		for _, p := range proc.ports() {
+			taken[*p.port] = true
+		}
+	}
+	var errs []error
+	for _, proc := range ps {
+		changed := false
+		for _, p := range proc.ports() {
+			if portFree(*p.port) {
+				continue
+			}
+			if cfg.KillStale {
+				desc, err := killStale(*p.port)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", proc.name, err))
+				} else {
+					fmt.Fprintf(proc.ensureLogger(), "Killed %s, which held %s %d\n", desc, p.env, *p.port)
+				}
+				continue
+			}
+			inUse := p.inUse()
+			if p.fixed {
+				errs = append(errs, fmt.Errorf("%s: %v; use -kill-stale to kill it", proc.name, inUse))
+				continue
+			}
+			next := *p.port + 1
+			for next <= 65535 && (taken[next] || !portFree(next)) {
+				next++
+			}
+			if next > 65535 {
+				errs = append(errs, fmt.Errorf("%s: no free port for %s after %d", proc.name, p.env, *p.port))
+				continue
+			}
+			fmt.Fprintf(proc.ensureLogger(), "%v, using %d\n", inUse, next)
+			taken[next] = true
+			*p.port = next
+			changed = true
+		}
+		if changed {
+			if err := proc.resolve(cfg); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
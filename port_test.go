@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPortEnvName(t *testing.T) {
+	for name, want := range map[string]string{
+		"admin":      "PORT_ADMIN",
+		"metrics-v2": "PORT_METRICS_V2",
+	} {
+		if got := portEnvName(name); got != want {
+			t.Errorf("portEnvName(%q): want %s, got %s", name, want, got)
+		}
+	}
+}
+
+func TestAllocatePorts(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	busy := uint(l.Addr().(*net.TCPAddr).Port)
+
+	procfile := filepath.Join(t.TempDir(), "Procfile")
+	if err := os.WriteFile(procfile, []byte("web: ./web -p $PORT -a $PORT_ADMIN\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config{
+		Procfile: procfile,
+		BasePort: busy,
+		Procs: map[string]*procConfig{
+			"web": {Ports: map[string]uint{"admin": 0, "metrics": 9090}},
+		},
+	}
+	if err := loadProcs(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := allocatePorts(cfg); err != nil {
+		t.Fatal(err)
+	}
+	web := findProc("web")
+	admin := busy + 1
+	if web.port == busy || web.port == admin {
+		t.Errorf("PORT: got %d, which is in use", web.port)
+	}
+	want := fmt.Sprintf("./web -p %s -a %s", shellVar("PORT"), shellVar("PORT_ADMIN"))
+	if got := web.execArgs[len(web.execArgs)-1]; got != want {
+		t.Errorf("command: want %q, got %q", want, got)
+	}
+	for _, kv := range []string{
+		fmt.Sprintf("PORT=%d", web.port),
+		fmt.Sprintf("PORT_ADMIN=%d", admin),
+		"PORT_METRICS=9090",
+	} {
+		if !slices.Contains(web.environ, kv) {
+			t.Errorf("environment does not contain %s", kv)
+		}
+	}
+	var status string
+	if err := (&Goreman{}).Status(RPCArgs{}, &status); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf(" web PORT=%d PORT_ADMIN=%d PORT_METRICS=9090\n", web.port, admin); status != want {
+		t.Errorf("status: want %q, got %q", want, status)
+	}
+
+	// a port set in .goreman is not moved.
+	cfg.Procs["web"] = &procConfig{Port: busy}
+	if err := loadProcs(cfg); err != nil {
+		t.Fatal(err)
+	}
+	err = allocatePorts(cfg)
+	if want := fmt.Sprintf("web: PORT %d is already in use", busy); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("want error %q, got %v", want, err)
+	}
+}
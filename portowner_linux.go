@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// portOwner returns the pid and the command line of the process listening
+// on port, from the sockets in /proc/net/tcp and the file descriptors in
+// /proc/*/fd. It returns 0 if the process is not found, e.g. because it
+// belongs to another user.
+func portOwner(port uint) (int, string, error) {
+	inodes := map[string]bool{}
+	for _, file := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		if err := listeningInodes(file, port, inodes); err != nil && !os.IsNotExist(err) {
+			return 0, "", err
+		}
+	}
+	if len(inodes) == 0 {
+		return 0, "", nil
+	}
+	fds, err := filepath.Glob("/proc/[0-9]*/fd/*")
+	if err != nil {
+		return 0, "", err
+	}
+	for _, fd := range fds {
+		link, err := os.Readlink(fd)
+		if err != nil || !strings.HasPrefix(link, "socket:[") {
+			continue
+		}
+		if !inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] {
+			continue
+		}
+		pid, err := strconv.Atoi(strings.Split(fd, "/")[2])
+		if err != nil {
+			continue
+		}
+		b, _ := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cmdline"))
+		return pid, strings.TrimSpace(strings.ReplaceAll(string(b), "\x00", " ")), nil
+	}
+	return 0, "", nil
+}
+
+// listeningInodes adds the inodes of the sockets listening on port in file,
+// in the format of /proc/net/tcp, to inodes.
+func listeningInodes(file string, port uint, inodes map[string]bool) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	s.Scan() // header
+	for s.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue tr:when retrnsmt uid timeout inode
+		fields := strings.Fields(s.Text())
+		if len(fields) < 10 || fields[3] != "0A" { // TCP_LISTEN
+			continue
+		}
+		_, hexPort, _ := strings.Cut(fields[1], ":")
+		if p, err := strconv.ParseUint(hexPort, 16, 16); err == nil && uint(p) == port {
+			inodes[fields[9]] = true
+		}
+	}
+	return s.Err()
+}
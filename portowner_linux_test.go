@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPortOwner(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := uint(l.Addr().(*net.TCPAddr).Port)
+	pid, cmdline, err := portOwner(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pid != os.Getpid() || !strings.HasPrefix(cmdline, os.Args[0]) {
+		t.Errorf("want pid %d (%s), got pid %d (%s)", os.Getpid(), os.Args[0], pid, cmdline)
+	}
+}
+
+func TestKillStale(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := uint(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+
+	// a leftover process from a previous session.
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), fmt.Sprintf("GOREMAN_TEST_LISTEN=:%d", port))
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+	defer cmd.Process.Kill()
+	for deadline := time.Now().Add(10 * time.Second); portFree(port); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the leftover process did not listen")
+		}
+	}
+
+	procfile := filepath.Join(t.TempDir(), "Procfile")
+	if err := os.WriteFile(procfile, []byte("web: ./web\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config{Procfile: procfile, Procs: map[string]*procConfig{"web": {Port: port}}}
+	if err := loadProcs(cfg); err != nil {
+		t.Fatal(err)
+	}
+	err = allocatePorts(cfg)
+	want := fmt.Sprintf("web: PORT %d is already in use by pid %d (%s); use -kill-stale", port, cmd.Process.Pid, os.Args[0])
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("want error %q, got %v", want, err)
+	}
+
+	cfg.KillStale = true
+	if err := allocatePorts(cfg); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Error("the leftover process was not killed")
+	}
+	if findProc("web").port != port {
+		t.Errorf("PORT was moved to %d", findProc("web").port)
+	}
+}
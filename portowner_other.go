@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// portOwner is only supported on Linux.
+func portOwner(port uint) (int, string, error) {
+	return 0, "", errors.ErrUnsupported
+}
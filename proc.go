@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"regexp"
 	"sync"
 	"time"
 )
@@ -12,35 +13,43 @@ import (
 // spawnProc starts the specified proc, and returns any error from running it.
 func spawnProc(name string, errCh chan<- error) {
 	proc := findProc(name)
-	logger := proc.logger
-	if logger == nil {
-		logger = createLogger(name, proc.colorIndex)
-		proc.logger = logger
-	}
+	logger := proc.ensureLogger()
 
-	cs := append(cmdStart, proc.cmdline)
+	cs := proc.execArgs
 	cmd := exec.Command(cs[0], cs[1:]...)
 	cmd.Stdin = nil
 	cmd.Stdout = logger
 	cmd.Stderr = logger
 	cmd.SysProcAttr = procAttrs
+	cmd.Dir = proc.dir
 
+	cmd.Env = proc.environ
 	if proc.setPort {
-		cmd.Env = append(os.Environ(), fmt.Sprintf("PORT=%d", proc.port))
 		fmt.Fprintf(logger, "Starting %s on port %d\n", name, proc.port)
 	}
-	if err := cmd.Start(); err != nil {
+	err := proc.checkPorts()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
 		select {
 		case errCh <- err:
 		default:
 		}
 		fmt.Fprintf(logger, "Failed to start %s: %s\n", name, err)
+		proc.waitErr = err
 		return
 	}
 	proc.cmd = cmd
 	proc.stoppedBySupervisor = false
 	proc.mu.Unlock()
-	err := cmd.Wait()
+	done := make(chan struct{})
+	if proc.healthCheck != nil {
+		go watchHealth(proc, cmd.Env, done)
+	}
+	err = cmd.Wait()
+	close(done)
+	proc.healthy.Store(false)
 	proc.mu.Lock()
 	proc.cond.Broadcast()
 	if err != nil && !proc.stoppedBySupervisor {
@@ -54,21 +63,55 @@ func spawnProc(name string, errCh chan<- error) {
 	fmt.Fprintf(logger, "Terminating %s\n", name)
 }
 
-// Stop the specified proc, issuing os.Kill if it does not terminate within 10
-// seconds. If signal is nil, os.Interrupt is used.
-func stopProc(name string, signal os.Signal) error {
-	if signal == nil {
-		signal = os.Interrupt
+// ensureLogger returns the logger of proc, creating it on first use.
+// proc.mu must be held.
+func (proc *procInfo) ensureLogger() *clogger {
+	if proc.logger == nil {
+		proc.logger = createLogger(proc.name, proc.colorIndex)
+	}
+	return proc.logger
+}
+
+// escape sequences, e.g. colors, removed from log lines.
+var ansiRe = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+// recentLog returns the last lines of the log of proc, without colors.
+func (proc *procInfo) recentLog() []string {
+	proc.mu.Lock()
+	logger := proc.logger
+	proc.mu.Unlock()
+	lines := []string{}
+	if logger != nil {
+		for _, line := range logger.recentLines() {
+			lines = append(lines, ansiRe.ReplaceAllString(line, ""))
+		}
 	}
+	return lines
+}
+
+// Stop the specified proc, issuing os.Kill if it does not terminate within
+// its stop timeout (10 seconds by default). If signal is nil, the stop signal
+// of the proc is used, or os.Interrupt.
+func stopProc(name string, signal os.Signal) error {
 	proc := findProc(name)
 	if proc == nil {
 		return errors.New("unknown proc: " + name)
 	}
+	if signal == nil {
+		signal = proc.stopSignal
+	}
+	if signal == nil {
+		signal = os.Interrupt
+	}
 
 	proc.mu.Lock()
 	defer proc.mu.Unlock()
 
 	if proc.cmd == nil {
+		if proc.pending {
+			// cancel the pending start.
+			proc.stoppedBySupervisor = true
+		}
 		return nil
 	}
 	proc.stoppedBySupervisor = true
@@ -78,7 +121,7 @@ func stopProc(name string, signal os.Signal) error {
 		return err
 	}
 
-	timeout := time.AfterFunc(10*time.Second, func() {
+	timeout := time.AfterFunc(proc.stopTimeout, func() {
 		proc.mu.Lock()
 		defer proc.mu.Unlock()
 		if proc.cmd != nil {
@@ -90,6 +133,23 @@ func stopProc(name string, signal os.Signal) error {
 	return err
 }
 
+// signalProc sends signal to the running proc name, without stopping it.
+func signalProc(name string, signal os.Signal) error {
+	proc := findProc(name)
+	if proc == nil {
+		return errors.New("unknown proc: " + name)
+	}
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	if proc.cmd == nil {
+		return errNotRunning
+	}
+	return terminateProc(proc, signal)
+}
+
+// errNotRunning is returned for a signal sent to a proc which is not running.
+var errNotRunning = errors.New("proc is not running")
+
 // start specified proc. if proc is started already, return nil.
 func startProc(name string, wg *sync.WaitGroup, errCh chan<- error) error {
 	proc := findProc(name)
@@ -98,7 +158,7 @@ func startProc(name string, wg *sync.WaitGroup, errCh chan<- error) error {
 	}
 
 	proc.mu.Lock()
-	if proc.cmd != nil {
+	if proc.cmd != nil || proc.pending {
 		proc.mu.Unlock()
 		return nil
 	}
@@ -106,8 +166,24 @@ func startProc(name string, wg *sync.WaitGroup, errCh chan<- error) error {
 	if wg != nil {
 		wg.Add(1)
 	}
+	proc.pending = true
+	proc.stoppedBySupervisor = false
 	go func() {
-		spawnProc(name, errCh)
+		if proc.waitDependencies() {
+			for {
+				proc.pending = false
+				spawnProc(name, errCh)
+				if !proc.shouldRestart() {
+					break
+				}
+				proc.pending = true
+				fmt.Fprintf(proc.ensureLogger(), "Restarting %s in %s\n", name, restartDelay)
+				if !proc.sleep(restartDelay) {
+					break
+				}
+			}
+		}
+		proc.pending = false
 		if wg != nil {
 			wg.Done()
 		}
@@ -116,6 +192,54 @@ func startProc(name string, wg *sync.WaitGroup, errCh chan<- error) error {
 	return nil
 }
 
+// delay before a proc is restarted by its restart policy.
+var restartDelay = time.Second
+
+// shouldRestart reports whether the restart policy of proc asks for it to
+// be restarted after it exited. proc.mu must be held.
+func (proc *procInfo) shouldRestart() bool {
+	if proc.stoppedBySupervisor {
+		return false
+	}
+	switch proc.restart {
+	case restartAlways:
+		return true
+	case restartOnFailure:
+		return proc.waitErr != nil
+	}
+	return false
+}
+
+// sleep waits for d with proc.mu unlocked, and reports whether the proc was
+// not stopped in the meantime.
+func (proc *procInfo) sleep(d time.Duration) bool {
+	proc.mu.Unlock()
+	time.Sleep(d)
+	proc.mu.Lock()
+	return !proc.stoppedBySupervisor
+}
+
+// waitDependencies waits with proc.mu unlocked until the procs proc depends
+// on are ready, and reports whether the proc was not stopped in the
+// meantime. Dependencies which are not loaded are ignored.
+func (proc *procInfo) waitDependencies() bool {
+	for _, name := range proc.dependsOn {
+		dep := findProc(name)
+		if dep == nil {
+			continue
+		}
+		if !dep.ready() {
+			fmt.Fprintf(proc.ensureLogger(), "Waiting for %s\n", name)
+		}
+		for !dep.ready() {
+			if !proc.sleep(100 * time.Millisecond) {
+				return false
+			}
+		}
+	}
+	return !proc.stoppedBySupervisor
+}
+
 // restart specified proc.
 func restartProc(name string, wg *sync.WaitGroup, errCh chan<- error) error {
 	if wg != nil {
@@ -133,7 +257,7 @@ func restartProc(name string, wg *sync.WaitGroup, errCh chan<- error) error {
 
 // stopProcs attempts to stop every running process and returns any non-nil
 // error, if one exists. stopProcs will wait until all procs have had an
-// opportunity to stop.
+// opportunity to stop. If sig is nil, each proc gets its stop signal.
 func stopProcs(sig os.Signal) error {
 	var err error
 	for _, proc := range procs {
@@ -151,7 +275,9 @@ func startProcs(sc <-chan os.Signal, rpcCh <-chan *rpcMessage, exitOnError bool)
 	errCh := make(chan error, 1)
 
 	for _, proc := range procs {
-		startProc(proc.name, &wg, errCh)
+		if proc.autostart {
+			startProc(proc.name, &wg, errCh)
+		}
 	}
 
 	allProcsDone := make(chan struct{}, 1)
@@ -198,16 +324,30 @@ func startProcs(sc <-chan os.Signal, rpcCh <-chan *rpcMessage, exitOnError bool)
 					}
 				}
 				close(rpcMsg.ErrCh)
+			case "signal":
+				// the first argument is the signal, the others procs.
+				sig, err := parseSignal(rpcMsg.Args[0])
+				if err != nil {
+					rpcMsg.ErrCh <- err
+				} else {
+					for _, proc := range rpcMsg.Args[1:] {
+						if err := signalProc(proc, sig); err != nil {
+							rpcMsg.ErrCh <- err
+							break
+						}
+					}
+				}
+				close(rpcMsg.ErrCh)
 			default:
 				panic("unimplemented rpc message type " + rpcMsg.Msg)
 			}
 		case err := <-errCh:
 			if exitOnError {
-				stopProcs(os.Interrupt)
+				stopProcs(nil)
 				return err
 			}
 		case <-allProcsDone:
-			return stopProcs(os.Interrupt)
+			return stopProcs(nil)
 		case sig := <-sc:
 			return stopProcs(sig)
 		}
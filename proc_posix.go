@@ -4,8 +4,11 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 
 	"golang.org/x/sys/unix"
 )
@@ -15,6 +18,11 @@ const sigterm = unix.SIGTERM
 const sighup = unix.SIGHUP
 
 var cmdStart = []string{"/bin/sh", "-c"}
+
+// shellVar returns a reference to the environment variable name for the
+// shell of cmdStart.
+func shellVar(name string) string { return "${" + name + "}" }
+
 var procAttrs = &unix.SysProcAttr{Setpgid: true}
 
 func terminateProc(proc *procInfo, signal os.Signal) error {
@@ -46,8 +54,33 @@ func killProc(process *os.Process) error {
 	return unix.Kill(-1*process.Pid, unix.SIGKILL)
 }
 
+// inForeground reports whether goreman is in the foreground process group of
+// the terminal on stdin, whose signals reach the children in the group too.
+func inForeground() bool {
+	pgrp, err := unix.IoctlGetInt(int(os.Stdin.Fd()), unix.TIOCGPGRP)
+	return err == nil && pgrp == unix.Getpgrp()
+}
+
 func notifyCh() <-chan os.Signal {
 	sc := make(chan os.Signal, 10)
 	signal.Notify(sc, sigterm, sigint, sighup)
 	return sc
 }
+
+// parseSignal parses a signal name such as SIGTERM or TERM.
+func parseSignal(name string) (os.Signal, error) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig := unix.SignalNum(name)
+	if sig == 0 {
+		return nil, fmt.Errorf("unknown signal: %s", name)
+	}
+	return sig, nil
+}
+
+// isAddrInUse reports whether err is from listening on a port in use.
+func isAddrInUse(err error) bool {
+	return errors.Is(err, unix.EADDRINUSE)
+}
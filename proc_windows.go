@@ -1,14 +1,22 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"golang.org/x/sys/windows"
 )
 
 var cmdStart = []string{"cmd", "/c"}
+
+// shellVar returns a reference to the environment variable name for the
+// shell of cmdStart.
+func shellVar(name string) string { return "%" + name + "%" }
+
 var procAttrs = &windows.SysProcAttr{
 	CreationFlags: windows.CREATE_UNICODE_ENVIRONMENT | windows.CREATE_NEW_PROCESS_GROUP,
 }
@@ -58,8 +66,31 @@ func killProc(process *os.Process) error {
 	return process.Kill()
 }
 
+// inForeground reports whether the signals of the console reach the
+// children too, which is always the case on Windows.
+func inForeground() bool {
+	return true
+}
+
 func notifyCh() <-chan os.Signal {
 	sc := make(chan os.Signal, 10)
 	signal.Notify(sc, os.Interrupt)
 	return sc
 }
+
+// parseSignal parses a signal name. Only SIGINT and SIGKILL can be sent on
+// Windows.
+func parseSignal(name string) (os.Signal, error) {
+	switch strings.TrimPrefix(strings.ToUpper(name), "SIG") {
+	case "INT":
+		return os.Interrupt, nil
+	case "KILL":
+		return os.Kill, nil
+	}
+	return nil, fmt.Errorf("unknown signal: %s", name)
+}
+
+// isAddrInUse reports whether err is from listening on a port in use.
+func isAddrInUse(err error) bool {
+	return errors.Is(err, windows.WSAEADDRINUSE)
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// procfileEntry is an entry of a Procfile.
+type procfileEntry struct {
+	name    string
+	command string   // "-" removes the entry in an overlay
+	argv    []string // command run without a shell, from a YAML Procfile
+	line    int      // line of the name
+	include string   // path of an #include directive, instead of an entry
+	warning string   // problem with a skipped line, instead of an entry
+	file    string   // file of the entry, set by readProcfiles
+}
+
+// procfileFlag is the value of -f, which can be repeated to add overlays.
+type procfileFlag struct {
+	files []string
+	set   bool
+}
+
+func (f *procfileFlag) String() string {
+	return strings.Join(f.files, ",")
+}
+
+func (f *procfileFlag) Set(s string) error {
+	if !f.set {
+		f.files, f.set = nil, true
+	}
+	f.files = append(f.files, s)
+	return nil
+}
+
+// names which need no quotes.
+var procNameRe = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// validProcName reports whether a quoted name is usable as a proc name,
+// which ends up in log prefixes, RPC arguments and exported file names.
+func validProcName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c <= ' ' || c == 0x7f || strings.ContainsRune(`/\"'`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseProcfile parses the content of a Procfile read from filename. Each
+// entry is "NAME: COMMAND". The command may continue on the next line after
+// a backslash, or inside quotes, like in sh. A # at the start of a line or
+// of a word outside quotes starts a comment, except for "#include PATH"
+// at the start of a line. A name containing characters
+// other than letters, digits, _, . and - must be double-quoted. A line
+// without a colon is skipped with a warning entry. All errors are returned,
+// prefixed with "filename:line: ".
+func parseProcfile(filename string, content string) ([]procfileEntry, error) {
+	var entries []procfileEntry
+	var errs []error
+	errorf := func(line int, format string, a ...any) {
+		errs = append(errs, fmt.Errorf("%s:%d: %s", filename, line, fmt.Sprintf(format, a...)))
+	}
+	seen := map[string]int{}
+
+	line := 1
+	i := 0
+	// skipLine skips to the start of the next line.
+	skipLine := func() {
+		for i < len(content) && content[i] != '\n' {
+			i++
+		}
+		if i < len(content) {
+			i++
+			line++
+		}
+	}
+	for i < len(content) {
+		// skip blank lines and comments.
+		for i < len(content) && (content[i] == ' ' || content[i] == '\t' || content[i] == '\r') {
+			i++
+		}
+		if i == len(content) {
+			break
+		}
+		if rest, ok := strings.CutPrefix(content[i:], "#include"); ok && (rest == "" || strings.ContainsRune(" \t", rune(rest[0]))) {
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			if path := strings.TrimSpace(rest[:end]); path != "" {
+				entries = append(entries, procfileEntry{include: path, line: line})
+			} else {
+				errorf(line, "#include without a path")
+			}
+			skipLine()
+			continue
+		}
+		if content[i] == '\n' || content[i] == '#' {
+			skipLine()
+			continue
+		}
+
+		// name
+		start := line
+		var name string
+		if content[i] == '"' {
+			end := strings.IndexAny(content[i+1:], "\"\n")
+			if end < 0 || content[i+1+end] != '"' {
+				errorf(start, "unterminated quoted name")
+				skipLine()
+				continue
+			}
+			name = content[i+1 : i+1+end]
+			i += end + 2
+			if !validProcName(name) {
+				errorf(start, "invalid proc name %q", name)
+				skipLine()
+				continue
+			}
+			for i < len(content) && (content[i] == ' ' || content[i] == '\t') {
+				i++
+			}
+			if i == len(content) || content[i] != ':' {
+				errorf(start, "expected ':' after proc name %q", name)
+				skipLine()
+				continue
+			}
+		} else {
+			end := strings.IndexAny(content[i:], ":\n")
+			if end < 0 || content[i+end] != ':' {
+				// such lines used to be skipped silently.
+				entries = append(entries, procfileEntry{line: start, warning: "skipping line without NAME: COMMAND"})
+				skipLine()
+				continue
+			}
+			name = strings.TrimSpace(content[i : i+end])
+			i += end
+			if !procNameRe.MatchString(name) {
+				errorf(start, "invalid proc name %q (quote names with other characters)", name)
+				skipLine()
+				continue
+			}
+		}
+		i++ // ':'
+
+		// command
+		var b strings.Builder
+		var quote byte
+		quoteLine := 0
+	command:
+		for i < len(content) {
+			c := content[i]
+			switch {
+			case quote == '\'':
+				if c == '\'' {
+					quote = 0
+				}
+			case c == '\\' && strings.HasPrefix(content[i+1:], "\n"):
+				// line continuation
+				i += 2
+				line++
+				continue
+			case c == '\\' && strings.HasPrefix(content[i+1:], "\r\n"):
+				i += 3
+				line++
+				continue
+			case c == '\\' && i+1 < len(content):
+				b.WriteByte(c)
+				c = content[i+1]
+				i++
+			case quote == '"':
+				if c == '"' {
+					quote = 0
+				}
+			case c == '\'' || c == '"':
+				quote, quoteLine = c, line
+			case c == '\n':
+				break command
+			case c == '#' && (b.Len() == 0 || strings.ContainsRune(" \t", rune(b.String()[b.Len()-1]))):
+				// comment up to the end of the line
+				for i < len(content) && content[i] != '\n' {
+					i++
+				}
+				break command
+			}
+			if c == '\n' {
+				line++
+			}
+			b.WriteByte(c)
+			i++
+		}
+		if quote != 0 {
+			errorf(quoteLine, "unterminated %c quote", quote)
+			break
+		}
+		command := strings.TrimSpace(strings.TrimSuffix(b.String(), "\r"))
+		switch {
+		case command == "":
+			errorf(start, "empty command for %s", name)
+		case seen[name] != 0:
+			errorf(start, "duplicate proc %s (first defined on line %d)", name, seen[name])
+		default:
+			seen[name] = start
+			entries = append(entries, procfileEntry{name: name, command: command, line: start})
+		}
+	}
+	return entries, errors.Join(errs...)
+}
+
+// procfiles returns the Procfile, or Procfile.yml if only that exists, its
+// overlays and then the Procfile.local
+// next to the Procfile, if any.
+func (cfg *config) procfiles() []string {
+	procfile := cfg.Procfile
+	if _, err := os.Stat(procfile); os.IsNotExist(err) {
+		if _, err := os.Stat(procfile + ".yml"); err == nil {
+			procfile += ".yml"
+		}
+	}
+	files := append([]string{procfile}, cfg.Overlays...)
+	local := filepath.Join(filepath.Dir(cfg.Procfile), "Procfile.local")
+	if _, err := os.Stat(local); err == nil && !slices.Contains(files, local) {
+		files = append(files, local)
+	}
+	return files
+}
+
+// readProcfiles reads the Procfile and its overlays. An entry in a later file
+// replaces the entry with the same name in place, or is added at the end,
+// and "NAME: -" removes it. An included file is read as if its content
+// appeared in place of the #include line, with the path relative to the
+// including file.
+func readProcfiles(files []string) ([]procfileEntry, error) {
+	var entries []procfileEntry
+	var errs []error
+	var read func(file string, stack []string) error
+	read = func(file string, stack []string) error {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		parse := parseProcfile
+		if isYAMLProcfile(file) {
+			parse = parseProcfileYAML
+		}
+		parsed, err := parse(file, string(content))
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, e := range parsed {
+			e.file = file
+			if e.warning != "" {
+				entries = append(entries, e)
+				continue
+			}
+			if e.include == "" {
+				i := slices.IndexFunc(entries, func(o procfileEntry) bool { return o.name == e.name })
+				switch {
+				case e.command == "-" && i >= 0:
+					entries = slices.Delete(entries, i, i+1)
+				case e.command == "-":
+				case i >= 0:
+					entries[i] = e
+				default:
+					entries = append(entries, e)
+				}
+				continue
+			}
+			path := e.include
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(filepath.Dir(file), path)
+			}
+			if slices.Contains(stack, path) {
+				errs = append(errs, fmt.Errorf("%s:%d: include cycle: %s", file, e.line, strings.Join(append(stack, path), " -> ")))
+				continue
+			}
+			if err := read(path, append(stack, path)); err != nil {
+				errs = append(errs, fmt.Errorf("%s:%d: %w", file, e.line, err))
+			}
+		}
+		return nil
+	}
+	for _, file := range files {
+		if err := read(file, []string{filepath.Clean(file)}); err != nil {
+			return nil, err
+		}
+	}
+	return entries, errors.Join(errs...)
+}
+
+func isYAMLProcfile(file string) bool {
+	ext := filepath.Ext(file)
+	return ext == ".yml" || ext == ".yaml"
+}
+
+// procfileYAMLEntry is an entry of a YAML Procfile.
+type procfileYAMLEntry struct {
+	// Command line run by the shell, or argv run directly.
+	Command yaml.Node `yaml:"command"`
+}
+
+// parseProcfileYAML parses a YAML Procfile, a mapping from proc names to
+// either a command line or a mapping with a command, which is a command line
+// run by the shell or a list of arguments run without one:
+//
+//	web: ./web --port $PORT
+//	worker:
+//	  command: [./worker, --queue, default]
+func parseProcfileYAML(filename string, content string) ([]procfileEntry, error) {
+	var entries []procfileEntry
+	var errs []error
+	errorf := func(line int, format string, a ...any) {
+		errs = append(errs, fmt.Errorf("%s:%d: %s", filename, line, fmt.Sprintf(format, a...)))
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(content), &doc); err != nil {
+		cfg := &config{file: filename}
+		return nil, cfg.yamlError(err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		errorf(root.Line, "expected a mapping of proc names to commands")
+		return nil, errors.Join(errs...)
+	}
+	seen := map[string]int{}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		name := key.Value
+		if !validProcName(name) {
+			errorf(key.Line, "invalid proc name %q", name)
+			continue
+		}
+		if line, ok := seen[name]; ok {
+			errorf(key.Line, "duplicate proc %s (first defined on line %d)", name, line)
+			continue
+		}
+		seen[name] = key.Line
+
+		command := value
+		if value.Kind == yaml.MappingNode {
+			var e procfileYAMLEntry
+			if err := value.Decode(&e); err != nil {
+				errorf(value.Line, "%s: %v", name, err)
+				continue
+			}
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				if k := value.Content[j].Value; k != "command" {
+					errorf(value.Content[j].Line, "%s: unknown key %s", name, k)
+				}
+			}
+			command = &e.Command
+		}
+		entry := procfileEntry{name: name, line: key.Line}
+		switch command.Kind {
+		case yaml.ScalarNode:
+			entry.command = strings.TrimSpace(command.Value)
+		case yaml.SequenceNode:
+			if err := command.Decode(&entry.argv); err != nil {
+				errorf(command.Line, "%s: %v", name, err)
+				continue
+			}
+			entry.command = shellJoin(entry.argv)
+		}
+		if entry.command == "" {
+			errorf(key.Line, "empty command for %s", name)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, errors.Join(errs...)
+}
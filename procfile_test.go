@@ -0,0 +1,186 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProcfile(t *testing.T) {
+	entries, err := parseProcfile("Procfile", `# comment
+web: ./web --port $PORT # listen on PORT
+
+worker: ./worker \
+  --queue default \
+  --verbose
+"api@v2": ./api '# not a comment' "a \" b" http://localhost/#top
+  clock:  sh -c 'echo one
+echo two'
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []procfileEntry{
+		{name: "web", command: "./web --port $PORT", line: 2},
+		{name: "worker", command: "./worker   --queue default   --verbose", line: 4},
+		{name: "api@v2", command: `./api '# not a comment' "a \" b" http://localhost/#top`, line: 7},
+		{name: "clock", command: "sh -c 'echo one\necho two'", line: 8},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("want %q, got %q", want, entries)
+	}
+}
+
+func TestParseProcfileErrors(t *testing.T) {
+	entries, err := parseProcfile("Procfile", `web: ./web
+just a command
+web: ./other
+bad name: ./bad
+"a/b": ./bad
+empty: # nothing
+worker: ./worker
+quote: echo 'oops
+`)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{
+		"Procfile:3: duplicate proc web (first defined on line 1)",
+		`Procfile:4: invalid proc name "bad name"`,
+		`Procfile:5: invalid proc name "a/b"`,
+		"Procfile:6: empty command for empty",
+		"Procfile:8: unterminated ' quote",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not contain %q:\n%v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "Procfile:2:") {
+		t.Errorf("a line without a colon should only be a warning:\n%v", err)
+	}
+	var names []string
+	var warnings []int
+	for _, e := range entries {
+		if e.warning != "" {
+			warnings = append(warnings, e.line)
+			continue
+		}
+		names = append(names, e.name)
+	}
+	if want := []int{2}; !reflect.DeepEqual(warnings, want) {
+		t.Errorf("want warnings on lines %v, got %v", want, warnings)
+	}
+	if want := []string{"web", "worker"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("want %v, got %v", want, names)
+	}
+}
+
+func TestReadProcfiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"Procfile":             "web: ./web\n#include sub/Procfile.db\nworker: ./worker\nclock: ./clock\n",
+		"sub/Procfile.db":      "db: ./db\n#include Procfile.cache\n",
+		"sub/Procfile.cache":   "cache: ./cache\n",
+		"Procfile.dev":         "web: ./web --dev\nclock: -\nmail: ./mail\n",
+		"Procfile.local":       "worker: ./worker --verbose\n",
+		"Procfile.cycle":       "#include Procfile.cycle\n",
+		"Procfile.nonexistent": "#include missing\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var f procfileFlag
+	f.files = []string{"Procfile"}
+	f.Set(filepath.Join(dir, "Procfile"))
+	f.Set(filepath.Join(dir, "Procfile.dev"))
+	cfg := &config{origins: map[string]string{}}
+	if err := settings[0].set(cfg, f.String()); err != nil {
+		t.Fatal(err)
+	}
+	files := cfg.procfiles()
+	want := []string{"Procfile", "Procfile.dev", "Procfile.local"}
+	for i := range want {
+		want[i] = filepath.Join(dir, want[i])
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Fatalf("want %q, got %q", want, files)
+	}
+
+	entries, err := readProcfiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.name+": "+e.command)
+	}
+	if want := []string{
+		"web: ./web --dev",
+		"db: ./db",
+		"cache: ./cache",
+		"worker: ./worker --verbose",
+		"mail: ./mail",
+	}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	_, err = readProcfiles([]string{filepath.Join(dir, "Procfile.cycle"), filepath.Join(dir, "Procfile.nonexistent")})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{
+		filepath.Join(dir, "Procfile.cycle") + ":1: include cycle",
+		filepath.Join(dir, "Procfile.nonexistent") + ":1: open " + filepath.Join(dir, "missing"),
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not contain %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestParseProcfileYAML(t *testing.T) {
+	entries, err := parseProcfileYAML("Procfile.yml", `web: ./web --port $PORT
+worker:
+  command: [./worker, --queue, "high priority"]
+clock:
+  command: ./clock
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []procfileEntry{
+		{name: "web", command: "./web --port $PORT", line: 1},
+		{name: "worker", command: "./worker --queue 'high priority'", argv: []string{"./worker", "--queue", "high priority"}, line: 2},
+		{name: "clock", command: "./clock", line: 4},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("want %q, got %q", want, entries)
+	}
+
+	_, err = parseProcfileYAML("Procfile.yml", `web:
+  cmd: ./web
+worker:
+  command: []
+"a b": ./ab
+`)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{
+		"Procfile.yml:2: web: unknown key cmd",
+		"Procfile.yml:3: empty command for worker",
+		`Procfile.yml:5: invalid proc name "a b"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not contain %q:\n%v", want, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// profile is a named overlay in the profiles section of .goreman, selected
+// with -profile or GOREMAN_PROFILE.
+type profile struct {
+	// Procs to load; all procs if empty.
+	Select []string `yaml:"select,omitempty"`
+	// Environment files loaded after the global ones.
+	EnvFiles []string `yaml:"envfiles,omitempty"`
+	// Formation merged onto the global formation.
+	Formation map[string]int `yaml:"formation,omitempty"`
+	// Per-proc settings merged onto the procs section.
+	Procs map[string]*procConfig `yaml:"procs,omitempty"`
+}
+
+// merge overrides the settings of pc with those set in o. Environments are
+// merged and environment files appended.
+func (pc *procConfig) merge(o *procConfig) {
+	if o.Cwd != "" {
+		pc.Cwd = o.Cwd
+	}
+	if len(o.Env) > 0 {
+		env := map[string]string{}
+		for k, v := range pc.Env {
+			env[k] = v
+		}
+		for k, v := range o.Env {
+			env[k] = v
+		}
+		pc.Env = env
+	}
+	pc.EnvFile = append(pc.EnvFile[:len(pc.EnvFile):len(pc.EnvFile)], o.EnvFile...)
+	if o.Port != 0 {
+		pc.Port = o.Port
+	}
+	if o.StopSignal != "" {
+		pc.StopSignal = o.StopSignal
+	}
+	if o.StopTimeout != 0 {
+		pc.StopTimeout = o.StopTimeout
+	}
+	if o.Restart != "" {
+		pc.Restart = o.Restart
+	}
+	if o.Color != "" {
+		pc.Color = o.Color
+	}
+	if o.DependsOn != nil {
+		pc.DependsOn = o.DependsOn
+	}
+	if o.HealthCheck != nil {
+		pc.HealthCheck = o.HealthCheck
+	}
+	if o.Autostart != nil {
+		pc.Autostart = o.Autostart
+	}
+}
+
+// applyProfile merges the profile named by cfg.Profile onto cfg. It does
+// nothing if no profile is selected or it was applied already.
+func applyProfile(cfg *config) error {
+	if cfg.Profile == "" || cfg.profileApplied {
+		return nil
+	}
+	p, ok := cfg.Profiles[cfg.Profile]
+	if !ok {
+		return cfg.errorf("profile", "unknown profile: %s", cfg.Profile)
+	}
+	cfg.profileApplied = true
+	if p == nil {
+		return nil
+	}
+
+	cfg.EnvFiles = append(cfg.EnvFiles, p.EnvFiles...)
+	if len(p.Formation) > 0 {
+		formation := map[string]int{}
+		for k, v := range cfg.Formation {
+			formation[k] = v
+		}
+		for k, v := range p.Formation {
+			formation[k] = v
+		}
+		cfg.Formation = formation
+	}
+	for name, o := range p.Procs {
+		if o == nil {
+			continue
+		}
+		if cfg.Procs == nil {
+			cfg.Procs = map[string]*procConfig{}
+		}
+		pc := &procConfig{}
+		if cfg.Procs[name] != nil {
+			*pc = *cfg.Procs[name]
+		}
+		pc.merge(o)
+		cfg.Procs[name] = pc
+	}
+	cfg.selected = p.Select
+
+	// errors and goreman config point at the profile for the keys it set.
+	prefix := "profiles." + cfg.Profile + "."
+	if cfg.origins == nil {
+		cfg.origins = map[string]string{}
+	}
+	for key, line := range cfg.lines {
+		if !strings.HasPrefix(key, prefix) || strings.HasPrefix(key, prefix+"select") {
+			continue
+		}
+		target := strings.TrimPrefix(key, prefix)
+		if _, ok := cfg.lines[target]; !ok {
+			cfg.lines[target] = line
+		}
+		cfg.origins[target] = cfg.file + ":" + strconv.Itoa(line)
+	}
+	return nil
+}
+
+// selectProcs keeps only the named procs, which are started even if
+// autostart is off.
+func selectProcs(names []string) error {
+	tmp := make([]*procInfo, 0, len(names))
+	maxProcNameLength = 0
+	for _, v := range names {
+		proc := findProc(v)
+		if proc == nil {
+			return errors.New("unknown proc: " + v)
+		}
+		proc.autostart = true
+		tmp = append(tmp, proc)
+		if len(v) > maxProcNameLength {
+			maxProcNameLength = len(v)
+		}
+	}
+	mu.Lock()
+	procs = tmp
+	mu.Unlock()
+	return nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// proxyConfig is the configuration of the reverse proxy in the proxy
+// section of .goreman.
+type proxyConfig struct {
+	// Address the proxy listens on, e.g. 127.0.0.1:8080. The proxy is not
+	// started if empty.
+	Listen string `yaml:"listen,omitempty"`
+	// Domain of the host names of the procs, e.g. web.localhost. Defaults
+	// to localhost.
+	Domain string `yaml:"domain,omitempty"`
+	// Procs by path prefix, e.g. /api: api.
+	Paths map[string]string `yaml:"paths,omitempty"`
+	// Proc for requests which match no host name or path.
+	Default string `yaml:"default,omitempty"`
+}
+
+func (pc *proxyConfig) domain() string {
+	if pc.Domain != "" {
+		return strings.ToLower(strings.Trim(pc.Domain, "."))
+	}
+	return "localhost"
+}
+
+// route returns the name of the proc for r: the proc named by the host
+// name, the proc of the longest matching path prefix, or the default proc.
+func (pc *proxyConfig) route(r *http.Request) string {
+	host := strings.ToLower(r.Host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if name, ok := strings.CutSuffix(host, "."+pc.domain()); ok && findProc(name) != nil {
+		return name
+	}
+
+	var route, name string
+	for prefix, n := range pc.Paths {
+		if len(prefix) > len(route) && pathHasPrefix(r.URL.Path, prefix) {
+			route, name = prefix, n
+		}
+	}
+	if name != "" {
+		return name
+	}
+	return pc.Default
+}
+
+// pathHasPrefix reports whether path is prefix or below it.
+func pathHasPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// newProxyHandler returns the handler of the proxy, which forwards requests
+// to the PORT of the proc given by route.
+func newProxyHandler(pc *proxyConfig) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := pc.route(r)
+		proc := findProc(name)
+		if proc == nil {
+			writeProxyPage(w, http.StatusNotFound, proxyPage{Host: r.Host, Procs: proxyProcs(pc, r.Host)})
+			return
+		}
+		if !proc.setPort {
+			writeProxyPage(w, http.StatusBadGateway, newProxyPage(proc, errors.New("proc has no port")))
+			return
+		}
+		target := &url.URL{Scheme: "http", Host: fmt.Sprintf("127.0.0.1:%d", proc.port)}
+		// ReverseProxy passes through upgraded connections, e.g. WebSockets.
+		rp := &httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.SetURL(target)
+				pr.SetXForwarded()
+				pr.Out.Host = pr.In.Host
+			},
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				writeProxyPage(w, http.StatusBadGateway, newProxyPage(proc, err))
+			},
+			// errors are shown on the error page instead of the log.
+			ErrorLog: log.New(io.Discard, "", 0),
+		}
+		rp.ServeHTTP(w, r)
+	})
+}
+
+// startProxy listens on the address of the proxy and serves it until ctx is
+// done.
+func startProxy(ctx context.Context, pc *proxyConfig) error {
+	l, err := net.Listen("tcp", pc.Listen)
+	if err != nil {
+		return fmt.Errorf("proxy: %w", err)
+	}
+	server := &http.Server{Handler: newProxyHandler(pc), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go server.Serve(l)
+	return nil
+}
+
+// proxyPage is the data of the page shown when a request cannot be proxied.
+type proxyPage struct {
+	Host   string
+	Name   string
+	Port   uint
+	Status string
+	Error  string
+	Log    []string
+	Procs  []proxyProc
+}
+
+// proxyProc is a proc listed on the page of an unknown route.
+type proxyProc struct {
+	Name   string
+	Host   string
+	Status string
+}
+
+// number of log lines shown on the error page.
+const proxyLogLines = 20
+
+func newProxyPage(proc *procInfo, err error) proxyPage {
+	page := proxyPage{Name: proc.name, Port: proc.port, Status: proc.status(), Error: err.Error()}
+	page.Log = proc.recentLog()
+	if len(page.Log) > proxyLogLines {
+		page.Log = page.Log[len(page.Log)-proxyLogLines:]
+	}
+	return page
+}
+
+// proxyProcs returns the procs with a port, with their host names on the
+// port of host.
+func proxyProcs(pc *proxyConfig, host string) []proxyProc {
+	port := ""
+	if _, p, err := net.SplitHostPort(host); err == nil {
+		port = ":" + p
+	}
+	mu.Lock()
+	ps := make([]*procInfo, len(procs))
+	copy(ps, procs)
+	mu.Unlock()
+	var list []proxyProc
+	for _, proc := range ps {
+		if proc.setPort {
+			list = append(list, proxyProc{Name: proc.name, Host: proc.name + "." + pc.domain() + port, Status: proc.status()})
+		}
+	}
+	return list
+}
+
+// status describes the state of proc for people.
+func (proc *procInfo) status() string {
+	proc.mu.Lock()
+	running, pending := proc.cmd != nil, proc.pending
+	proc.mu.Unlock()
+	switch {
+	case running && proc.healthCheck != nil && !proc.healthy.Load():
+		return "running, not healthy"
+	case running:
+		return "running"
+	case pending:
+		return "waiting for dependencies"
+	}
+	return "stopped"
+}
+
+var proxyTemplate = template.Must(template.New("proxy").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>goreman: {{if .Name}}{{.Name}} is unavailable{{else}}no proc for {{.Host}}{{end}}</title></head>
+<body>
+{{- if .Name}}
+<h1>{{.Name}} is unavailable</h1>
+<p>Status: {{.Status}}, port {{.Port}}</p>
+<p>{{.Error}}</p>
+{{- if .Log}}
+<h2>Recent log</h2>
+<pre>{{range .Log}}{{.}}
+{{end}}</pre>
+{{- end}}
+{{- else}}
+<h1>No proc for {{.Host}}</h1>
+<ul>
+{{- range .Procs}}
+<li><a href="//{{.Host}}/">{{.Name}}</a> ({{.Status}})</li>
+{{- end}}
+</ul>
+{{- end}}
+</body>
+</html>
+`))
+
+func writeProxyPage(w http.ResponseWriter, code int, page proxyPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	proxyTemplate.Execute(w, page)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") == "websocket" {
+			conn, rw, err := http.NewResponseController(w).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+			rw.Flush()
+			line, _ := rw.ReadString('\n')
+			rw.WriteString("echo " + line)
+			rw.Flush()
+			return
+		}
+		fmt.Fprintf(w, "web %s %s", r.Host, r.URL.Path)
+	}))
+	defer backend.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := uint(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+
+	procfile := filepath.Join(t.TempDir(), "Procfile")
+	if err := os.WriteFile(procfile, []byte("web: ./web\napi: ./api\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config{
+		Procfile: procfile,
+		Procs: map[string]*procConfig{
+			"web": {Port: uint(backend.Listener.Addr().(*net.TCPAddr).Port)},
+			"api": {Port: down},
+		},
+		Proxy: proxyConfig{Paths: map[string]string{"/api": "api", "/": "web"}},
+	}
+	if err := loadProcs(cfg); err != nil {
+		t.Fatal(err)
+	}
+	api := findProc("api")
+	api.mu.Lock()
+	fmt.Fprintf(api.ensureLogger(), "\x1b[31mlisten failed: <bad>\x1b[m\n")
+	api.mu.Unlock()
+
+	proxy := httptest.NewServer(newProxyHandler(&cfg.Proxy))
+	defer proxy.Close()
+	get := func(host, path string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, proxy.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Host = host
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(body)
+	}
+
+	if code, body := get("web.localhost:8080", "/a"); code != http.StatusOK || body != "web web.localhost:8080 /a" {
+		t.Errorf("web.localhost: got %d %q", code, body)
+	}
+	if code, body := get("example.com", "/static"); code != http.StatusOK || body != "web example.com /static" {
+		t.Errorf("path /: got %d %q", code, body)
+	}
+	for _, req := range [][2]string{{"api.localhost", "/"}, {"example.com", "/api/users"}} {
+		code, body := get(req[0], req[1])
+		if code != http.StatusBadGateway {
+			t.Errorf("%s%s: want 502, got %d", req[0], req[1], code)
+		}
+		for _, want := range []string{"api is unavailable", "Status: stopped", "listen failed: &lt;bad&gt;\n"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("%s%s: want %q in page, got:\n%s", req[0], req[1], want, body)
+			}
+		}
+	}
+	cfg.Proxy.Paths = nil
+	if code, body := get("example.com", "/"); code != http.StatusNotFound || !strings.Contains(body, `<a href="//web.localhost/">web</a> (stopped)`) {
+		t.Errorf("unknown host: got %d:\n%s", code, body)
+	}
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: web.localhost\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	r := bufio.NewReader(conn)
+	res, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("websocket: want 101, got %d", res.StatusCode)
+	}
+	fmt.Fprintf(conn, "ping\n")
+	if line, err := r.ReadString('\n'); err != nil || line != "echo ping\n" {
+		t.Errorf("websocket: got %q, %v", line, err)
+	}
+}
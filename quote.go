@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// shellQuote quotes s for a POSIX shell. Nothing is special inside single
+// quotes, so only single quotes themselves need care.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+var shellSafeRe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellJoin joins args into a command line for sh, quoting only the
+// arguments which need it.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if shellSafeRe.MatchString(arg) {
+			quoted[i] = arg
+		} else {
+			quoted[i] = shellQuote(arg)
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// dotenvQuote quotes s for a double-quoted value of a dotenv file, so that
+// reading it back does not expand variables.
+func dotenvQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, `$`, `\$`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// upstartQuote quotes s for an upstart stanza such as env. upstart strips
+// double quotes and removes the backslash before a quoted character, so a
+// newline cannot be escaped.
+func upstartQuote(s string) (string, error) {
+	if strings.ContainsAny(s, "\r\n") {
+		return "", fmt.Errorf("upstart cannot quote a line break in %q", s)
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return `"` + r.Replace(s) + `"`, nil
+}
+
+// systemdQuote quotes s for a systemd unit directive such as Environment.
+// Inside double quotes systemd understands C escapes, and % starts a
+// specifier in every directive.
+func systemdQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", `\r`, `%`, `%%`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// systemdExecQuote quotes s as a single argument of ExecStart, where $ also
+// starts a variable reference.
+func systemdExecQuote(s string) string {
+	return strings.Replace(systemdQuote(s), "$", "$$", -1)
+}
+
+// yamlQuote quotes s as a YAML double-quoted scalar. Go escapes are a
+// subset of the escapes YAML understands.
+func yamlQuote(s string) string {
+	return strconv.Quote(s)
+}
+
+// xmlEscape escapes s for use as XML character data.
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+var quoteTests = []string{
+	"",
+	"plain",
+	"it's",
+	`say "hi"`,
+	`back\slash`,
+	"costs $5 or ${PRICE}",
+	"100%",
+	"first line\nsecond line",
+	"tab\there",
+}
+
+func TestShellQuote(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs /bin/sh")
+	}
+	for _, s := range quoteTests {
+		b, err := exec.Command("/bin/sh", "-c", "printf %s "+shellQuote(s)).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != s {
+			t.Errorf("shellQuote(%q): shell read %q", s, b)
+		}
+	}
+}
+
+func TestShellJoin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs /bin/sh")
+	}
+	b, err := exec.Command("/bin/sh", "-c", "printf '[%s]' "+shellJoin(quoteTests)).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "[" + strings.Join(quoteTests, "][") + "]"
+	if string(b) != want {
+		t.Errorf("shellJoin: shell read %q, want %q", b, want)
+	}
+	if got := shellJoin([]string{"./web", "--port=5000", "a b"}); got != "./web --port=5000 'a b'" {
+		t.Errorf("shellJoin quotes too much: %s", got)
+	}
+}
+
+func TestYAMLQuote(t *testing.T) {
+	for _, s := range quoteTests {
+		var got string
+		if err := yaml.Unmarshal([]byte("v: "+yamlQuote(s)), &struct{ V *string }{&got}); err != nil {
+			t.Fatalf("yamlQuote(%q): %v", s, err)
+		}
+		if got != s {
+			t.Errorf("yamlQuote(%q): YAML read %q", s, got)
+		}
+	}
+}
+
+func TestUpstartQuote(t *testing.T) {
+	for s, want := range map[string]string{
+		"it's":       `"it's"`,
+		`say "hi"`:   `"say \"hi\""`,
+		`back\slash`: `"back\\slash"`,
+		"costs $5":   `"costs $5"`,
+	} {
+		if got, err := upstartQuote(s); err != nil || got != want {
+			t.Errorf("upstartQuote(%q): got %s, %v, want %s", s, got, err, want)
+		}
+	}
+	if got, err := upstartQuote("first line\nsecond line"); err == nil {
+		t.Errorf("expected an error for a newline, got %s", got)
+	}
+}
+
+func TestSystemdQuote(t *testing.T) {
+	for s, want := range map[string]string{
+		"it's":                    `"it's"`,
+		`say "hi"`:                `"say \"hi\""`,
+		"100%":                    `"100%%"`,
+		"first line\nsecond line": `"first line\nsecond line"`,
+		"costs $5":                `"costs $5"`,
+	} {
+		if got := systemdQuote(s); got != want {
+			t.Errorf("systemdQuote(%q): got %s, want %s", s, got, want)
+		}
+	}
+	if got, want := systemdExecQuote("echo $PORT"), `"echo $$PORT"`; got != want {
+		t.Errorf("systemdExecQuote: got %s, want %s", got, want)
+	}
+}
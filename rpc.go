@@ -1,11 +1,21 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"net/rpc"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -13,6 +23,16 @@ import (
 // Goreman is RPC server
 type Goreman struct {
 	rpcChan chan<- *rpcMessage
+	// token which every call must present.
+	token string
+}
+
+// RPCArgs are the arguments of every RPC call.
+type RPCArgs struct {
+	// Token authenticating the caller.
+	Token string
+	// Names of the procs.
+	Args []string
 }
 
 type rpcMessage struct {
@@ -34,32 +54,41 @@ func (r *Goreman) rpcExec(msg string, args []string) error {
 }
 
 // Start do start
-func (r *Goreman) Start(args []string, ret *string) (err error) {
+func (r *Goreman) Start(args RPCArgs, ret *string) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("%v", r)
 		}
 	}()
-	return r.rpcExec("start", args)
+	if err := r.authorize(args.Token); err != nil {
+		return err
+	}
+	return r.rpcExec("start", args.Args)
 }
 
 // Stop do stop
-func (r *Goreman) Stop(args []string, ret *string) (err error) {
+func (r *Goreman) Stop(args RPCArgs, ret *string) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("%v", r)
 		}
 	}()
-	return r.rpcExec("stop", args)
+	if err := r.authorize(args.Token); err != nil {
+		return err
+	}
+	return r.rpcExec("stop", args.Args)
 }
 
 // StopAll do stop all
-func (r *Goreman) StopAll(args []string, ret *string) (err error) {
+func (r *Goreman) StopAll(args RPCArgs, ret *string) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("%v", r)
 		}
 	}()
+	if err := r.authorize(args.Token); err != nil {
+		return err
+	}
 	for _, proc := range procs {
 		if err = stopProc(proc.name, nil); err != nil {
 			break
@@ -69,32 +98,41 @@ func (r *Goreman) StopAll(args []string, ret *string) (err error) {
 }
 
 // Restart do restart
-func (r *Goreman) Restart(args []string, ret *string) (err error) {
+func (r *Goreman) Restart(args RPCArgs, ret *string) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("%v", r)
 		}
 	}()
-	return r.rpcExec("restart", args)
+	if err := r.authorize(args.Token); err != nil {
+		return err
+	}
+	return r.rpcExec("restart", args.Args)
 }
 
 // RestartAll do restart all
-func (r *Goreman) RestartAll(args []string, ret *string) (err error) {
+func (r *Goreman) RestartAll(args RPCArgs, ret *string) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("%v", r)
 		}
 	}()
+	if err := r.authorize(args.Token); err != nil {
+		return err
+	}
 	return r.rpcExec("restart", nil)
 }
 
 // List do list
-func (r *Goreman) List(args []string, ret *string) (err error) {
+func (r *Goreman) List(args RPCArgs, ret *string) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("%v", r)
 		}
 	}()
+	if err := r.authorize(args.Token); err != nil {
+		return err
+	}
 	*ret = ""
 	for _, proc := range procs {
 		*ret += proc.name + "\n"
@@ -103,12 +141,15 @@ func (r *Goreman) List(args []string, ret *string) (err error) {
 }
 
 // Status do status
-func (r *Goreman) Status(args []string, ret *string) (err error) {
+func (r *Goreman) Status(args RPCArgs, ret *string) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("%v", r)
 		}
 	}()
+	if err := r.authorize(args.Token); err != nil {
+		return err
+	}
 	mu.Lock()
 	ps := make([]*procInfo, len(procs))
 	copy(ps, procs)
@@ -118,91 +159,417 @@ func (r *Goreman) Status(args []string, ret *string) (err error) {
 		proc.mu.Lock()
 		running := proc.cmd != nil
 		proc.mu.Unlock()
+		line := " " + proc.name
 		if running {
-			*ret += "*" + proc.name + "\n"
-		} else {
-			*ret += " " + proc.name + "\n"
+			line = "*" + proc.name
+		}
+		for _, p := range proc.ports() {
+			line += fmt.Sprintf(" %s=%d", p.env, *p.port)
 		}
+		*ret += line + "\n"
 	}
 	return err
 }
 
+// Signal sends the signal in Args[0], e.g. SIGHUP, to the running procs in
+// the rest of Args.
+func (r *Goreman) Signal(args RPCArgs, ret *string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	if err := r.authorize(args.Token); err != nil {
+		return err
+	}
+	if len(args.Args) < 2 {
+		return errors.New("signal needs a signal and procs")
+	}
+	if _, err := parseSignal(args.Args[0]); err != nil {
+		return err
+	}
+	return r.rpcExec("signal", args.Args)
+}
+
+// Logs returns the last log lines of the proc in Args[0].
+func (r *Goreman) Logs(args RPCArgs, ret *[]string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	if err := r.authorize(args.Token); err != nil {
+		return err
+	}
+	if len(args.Args) != 1 {
+		return errors.New("logs needs a proc")
+	}
+	proc := findProc(args.Args[0])
+	if proc == nil {
+		return errors.New("unknown proc: " + args.Args[0])
+	}
+	*ret = proc.recentLog()
+	return nil
+}
+
+// Procs returns the state of the procs in Args, or of every proc.
+func (r *Goreman) Procs(args RPCArgs, ret *[]apiProc) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	if err := r.authorize(args.Token); err != nil {
+		return err
+	}
+	mu.Lock()
+	ps := make([]*procInfo, len(procs))
+	copy(ps, procs)
+	mu.Unlock()
+	*ret = []apiProc{}
+	for _, proc := range ps {
+		if len(args.Args) == 0 || slices.Contains(args.Args, proc.name) {
+			*ret = append(*ret, newAPIProc(proc))
+		}
+	}
+	return nil
+}
+
 // command: run.
-func run(cmd string, args []string, serverPort uint) error {
-	client, err := rpc.Dial("tcp", defaultServer(serverPort))
+func run(cfg *config, cmd string, args []string) error {
+	client, err := dialRPC(cfg)
 	if err != nil {
 		return err
 	}
 	defer client.Close()
 	var ret string
+	rargs := RPCArgs{Token: readRPCToken(cfg), Args: args}
+	call := func(method string, reply any) error {
+		err := client.Call(method, rargs, reply)
+		// an older server fails to decode RPCArgs into its []string.
+		if e, ok := err.(rpc.ServerError); ok && strings.Contains(string(e), "remote type RPCArgs") {
+			return errOldRPCServer
+		}
+		return err
+	}
 	switch cmd {
 	case "start":
-		return client.Call("Goreman.Start", args, &ret)
+		return call("Goreman.Start", &ret)
 	case "stop":
-		return client.Call("Goreman.Stop", args, &ret)
+		return call("Goreman.Stop", &ret)
 	case "stop-all":
-		return client.Call("Goreman.StopAll", args, &ret)
+		return call("Goreman.StopAll", &ret)
 	case "restart":
-		return client.Call("Goreman.Restart", args, &ret)
+		return call("Goreman.Restart", &ret)
 	case "restart-all":
-		return client.Call("Goreman.RestartAll", args, &ret)
+		return call("Goreman.RestartAll", &ret)
 	case "list":
-		err := client.Call("Goreman.List", args, &ret)
+		err := call("Goreman.List", &ret)
 		fmt.Print(ret)
 		return err
 	case "status":
-		err := client.Call("Goreman.Status", args, &ret)
+		err := call("Goreman.Status", &ret)
 		fmt.Print(ret)
 		return err
+	case "signal":
+		return call("Goreman.Signal", &ret)
+	case "logs":
+		var lines []string
+		err := call("Goreman.Logs", &lines)
+		for _, line := range lines {
+			fmt.Println(line)
+		}
+		return err
 	}
 	return errors.New("unknown command")
 }
 
-// start rpc server.
-func startServer(ctx context.Context, rpcChan chan<- *rpcMessage, listenPort uint) error {
+// name of the RPC socket in the project directory.
+const socketFile = ".goreman.sock"
+
+// rpcSocketPath returns the path of the RPC Unix socket: the first one in
+// the listen setting, the configured path, or a socket named after the project directory under
+// XDG_RUNTIME_DIR, or .goreman.sock in the project directory.
+func rpcSocketPath(cfg *config) (string, error) {
+	for _, s := range cfg.Listen {
+		if path, ok := strings.CutPrefix(s, "unix:"); ok {
+			return path, nil
+		}
+	}
+	if cfg.Socket != "" {
+		return cfg.Socket, nil
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return "", err
+		}
+		sum := sha256.Sum256([]byte(wd))
+		return filepath.Join(dir, "goreman-"+hex.EncodeToString(sum[:8])+".sock"), nil
+	}
+	return socketFile, nil
+}
+
+// listenUnix listens on the Unix socket at path, accessible only by the
+// user. A socket left behind by a goreman which is no longer running is
+// replaced.
+func listenUnix(path string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: another goreman is listening", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// rpcPortSet reports whether the RPC port was set explicitly, with a flag,
+// the environment or the config file.
+func rpcPortSet(cfg *config) bool {
+	origin, ok := cfg.origins["port"]
+	return ok && origin != "default"
+}
+
+// dialRPC connects to the RPC server of the project through its Unix
+// socket. TCP is used if a server or port was given on the command line or
+// in the environment, or if the socket cannot be reached and the port was
+// set in the config file.
+func dialRPC(cfg *config) (*rpc.Client, error) {
+	_, server := os.LookupEnv("GOREMAN_RPC_SERVER")
+	origin := cfg.origins["port"]
+	if !server && !strings.HasPrefix(origin, "-") && !strings.HasPrefix(origin, "$") {
+		path, err := rpcSocketPath(cfg)
+		if err != nil {
+			return nil, err
+		}
+		client, err := rpc.Dial("unix", path)
+		if err == nil || !rpcPortSet(cfg) {
+			return client, err
+		}
+	}
+	if !cfg.TLS {
+		return rpc.Dial("tcp", defaultServer(cfg.Port))
+	}
+	tc, err := clientTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := tls.Dial("tcp", defaultServer(cfg.Port), tc)
+	if err != nil {
+		return nil, err
+	}
+	return rpc.NewClient(conn), nil
+}
+
+// rpcServer is the RPC server of goreman start.
+type rpcServer struct {
+	server      *rpc.Server
+	listeners   []net.Listener
+	removeToken func()
+	// HTTP API, served on the connections which start with an HTTP request.
+	http      *http.Server
+	httpConns *connListener
+
+	mu sync.Mutex
+	// connections served with net/rpc, or not sniffed yet.
+	conns   map[net.Conn]struct{}
+	closing bool
+}
+
+// rpcListener is an address the RPC server listens on.
+type rpcListener struct {
+	network, address string
+}
+
+// rpcListeners returns the addresses of the RPC server: those of the listen
+// setting, or the Unix socket of the project, and the RPC port on
+// defaultAddr if the port or address was set explicitly. An address is
+// "unix:PATH", "HOST:PORT", or a HOST to listen on the RPC port.
+func rpcListeners(cfg *config) ([]rpcListener, error) {
+	port := strconv.FormatUint(uint64(cfg.Port), 10)
+	if len(cfg.Listen) == 0 {
+		path, err := rpcSocketPath(cfg)
+		if err != nil {
+			return nil, err
+		}
+		addrs := []rpcListener{{"unix", path}}
+		// several projects can run at once on their own sockets, but not
+		// on the same port.
+		if _, ok := os.LookupEnv("GOREMAN_RPC_ADDR"); ok || rpcPortSet(cfg) {
+			addrs = append([]rpcListener{{"tcp", net.JoinHostPort(defaultAddr(), port)}}, addrs...)
+		}
+		return addrs, nil
+	}
+	var addrs []rpcListener
+	for _, s := range cfg.Listen {
+		if path, ok := strings.CutPrefix(s, "unix:"); ok {
+			addrs = append(addrs, rpcListener{"unix", path})
+		} else if _, _, err := net.SplitHostPort(s); err == nil {
+			addrs = append(addrs, rpcListener{"tcp", s})
+		} else {
+			addrs = append(addrs, rpcListener{"tcp", net.JoinHostPort(s, port)})
+		}
+	}
+	return addrs, nil
+}
+
+// newRPCServer listens on the addresses of the RPC server. Serving starts
+// with serve.
+func newRPCServer(cfg *config, rpcChan chan<- *rpcMessage) (*rpcServer, error) {
 	gm := &Goreman{
 		rpcChan: rpcChan,
 	}
-	// use a dedicated rpc.Server so repeated startServer calls in one
-	// process do not keep serving a previously registered instance.
-	rpcServer := rpc.NewServer()
-	if err := rpcServer.Register(gm); err != nil {
-		return err
+	// use a dedicated rpc.Server so repeated calls in one process do not
+	// keep serving a previously registered instance.
+	s := &rpcServer{server: rpc.NewServer(), conns: map[net.Conn]struct{}{}}
+	if err := s.server.Register(gm); err != nil {
+		return nil, err
+	}
+	s.http = &http.Server{Handler: gm.apiHandler(), ReadHeaderTimeout: 10 * time.Second}
+	s.httpConns = newConnListener()
+	addrs, err := rpcListeners(cfg)
+	if err == nil {
+		err = s.listen(cfg, addrs)
+	}
+	// create the token only once the socket shows that no other goreman
+	// uses the same token file.
+	if err == nil {
+		gm.token, s.removeToken, err = createRPCToken(cfg)
 	}
-	server, err := net.Listen("tcp", fmt.Sprintf("%s:%d", defaultAddr(), listenPort))
 	if err != nil {
-		return err
+		s.close()
+		return nil, err
 	}
+	return s, nil
+}
+
+func (s *rpcServer) listen(cfg *config, addrs []rpcListener) error {
+	var tc *tls.Config
+	for _, addr := range addrs {
+		if addr.network == "unix" {
+			l, err := listenUnix(addr.address)
+			if err != nil {
+				return err
+			}
+			s.listeners = append(s.listeners, l)
+			continue
+		}
+		l, err := net.Listen("tcp", addr.address)
+		if err != nil {
+			return rpcListenError(addr.address, err)
+		}
+		if cfg.TLS {
+			if tc == nil {
+				if tc, err = serverTLSConfig(cfg); err != nil {
+					l.Close()
+					return err
+				}
+			}
+			l = tls.NewListener(l, tc)
+		}
+		s.listeners = append(s.listeners, l)
+	}
+	return nil
+}
+
+// rpcListenError explains an error listening on the TCP address addr.
+func rpcListenError(addr string, err error) error {
+	_, p, _ := net.SplitHostPort(addr)
+	port, perr := strconv.ParseUint(p, 10, 16)
+	if !isAddrInUse(err) || perr != nil {
+		return fmt.Errorf("RPC server: %w", err)
+	}
+	rpcPort := uint(port)
+	err = procPort{env: "RPC port", port: &rpcPort}.inUse()
+	return fmt.Errorf("%s: %w; use -p to choose another port, or -rpc-server=false", addr, err)
+}
+
+func (s *rpcServer) close() {
+	for _, l := range s.listeners {
+		l.Close()
+	}
+}
+
+// track adds conn to the connections to stop reading on shutdown, or
+// reports false if the server is shutting down.
+func (s *rpcServer) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *rpcServer) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+}
+
+// stopReading makes the pending reads of every connection fail, so that
+// idle connections end while calls in progress still get their responses.
+func (s *rpcServer) stopReading() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closing = true
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+}
+
+// serve serves RPC calls until ctx is done, and then waits for the calls in
+// progress.
+func (s *rpcServer) serve(ctx context.Context) error {
+	defer s.removeToken()
 	go func() {
 		<-ctx.Done()
-		server.Close()
+		s.close()
+		s.stopReading()
 	}()
-	var wg sync.WaitGroup
-	var acceptingConns = true
-	for acceptingConns {
-		conns := make(chan net.Conn, 1)
+	go s.http.Serve(s.httpConns)
+
+	var accepting, wg sync.WaitGroup
+	for _, l := range s.listeners {
+		accepting.Add(1)
 		go func() {
-			conn, err := server.Accept()
-			if err != nil {
-				return
+			defer accepting.Done()
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				if !s.track(conn) {
+					conn.Close()
+					continue
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer s.untrack(conn)
+					s.serveConn(conn)
+				}()
 			}
-			conns <- conn
 		}()
-		select {
-		case <-ctx.Done():
-			acceptingConns = false
-			break
-		case client := <-conns: // server is not canceled.
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				rpcServer.ServeConn(client)
-			}()
-		}
 	}
+	<-ctx.Done()
+	accepting.Wait()
 	done := make(chan struct{}, 1)
 	go func() {
 		wg.Wait()
+		s.http.Shutdown(context.Background())
 		done <- struct{}{}
 	}()
 	select {
@@ -212,3 +579,83 @@ func startServer(ctx context.Context, rpcChan chan<- *rpcMessage, listenPort uin
 		return errors.New("RPC server did not shut down in 10 seconds, quitting")
 	}
 }
+
+// methods of HTTP requests, by their first four bytes.
+var httpMethods = []string{"GET ", "HEAD", "POST", "PUT ", "PATC", "DELE", "OPTI"}
+
+// serveConn serves the connection with JSON-RPC 2.0 if it starts with a
+// JSON object, with the HTTP API if it starts with an HTTP request, and else
+// with net/rpc.
+func (s *rpcServer) serveConn(conn net.Conn) {
+	br := bufio.NewReader(conn)
+	pc := &peekedConn{conn, br}
+	// peek a byte at a time past any whitespace, so that a short JSON
+	// request does not wait for more.
+	for n := 1; n <= br.Size(); n++ {
+		head, err := br.Peek(n)
+		if err != nil {
+			break
+		}
+		if c := head[n-1]; c == '{' {
+			s.server.ServeCodec(newJSONRPCCodec(pc))
+			return
+		} else if !strings.ContainsRune(" \t\r\n", rune(c)) {
+			break
+		}
+	}
+	if head, _ := br.Peek(4); slices.Contains(httpMethods, string(head)) {
+		// the HTTP server closes idle connections itself on shutdown.
+		s.untrack(conn)
+		s.httpConns.push(pc)
+		return
+	}
+	s.server.ServeCodec(newGobCodec(pc))
+}
+
+// peekedConn is a connection whose first bytes were read into r.
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// connListener is a net.Listener which accepts the connections pushed to it.
+type connListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newConnListener() *connListener {
+	return &connListener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+// push hands conn to Accept, or closes it if the listener is closed.
+func (l *connListener) push(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: "goreman", Net: "unix"}
+}
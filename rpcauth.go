@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// errRPCToken is returned by RPC calls with a missing or wrong token.
+var errRPCToken = errors.New("invalid RPC token")
+
+// rpcStatePath returns the path of a file of the RPC server with the given
+// suffix, next to its socket, e.g. .goreman.token.
+func rpcStatePath(cfg *config, suffix string) (string, error) {
+	path, err := rpcSocketPath(cfg)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(path, ".sock") + suffix, nil
+}
+
+// createRPCToken returns the token of the RPC server: GOREMAN_RPC_TOKEN if
+// set, or a new random token written to a file only the user can read. The
+// returned function removes the file.
+func createRPCToken(cfg *config) (string, func(), error) {
+	if token := os.Getenv("GOREMAN_RPC_TOKEN"); token != "" {
+		return token, func() {}, nil
+	}
+	path, err := rpcStatePath(cfg, ".token")
+	if err != nil {
+		return "", nil, err
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	token := hex.EncodeToString(b)
+	// never write the token into a file which others could already read.
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return "", nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", nil, err
+	}
+	_, err = f.WriteString(token + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", nil, err
+	}
+	return token, func() { os.Remove(path) }, nil
+}
+
+// readRPCToken returns the token for calls to the RPC server of the
+// project: GOREMAN_RPC_TOKEN if set, or the content of its token file.
+func readRPCToken(cfg *config) string {
+	if token := os.Getenv("GOREMAN_RPC_TOKEN"); token != "" {
+		return token
+	}
+	path, err := rpcStatePath(cfg, ".token")
+	if err != nil {
+		return ""
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// authorize checks the token of an RPC call.
+func (r *Goreman) authorize(token string) error {
+	if subtle.ConstantTimeCompare([]byte(token), []byte(r.token)) != 1 {
+		return errRPCToken
+	}
+	return nil
+}
+
+// name the server certificate is issued to, and which clients verify,
+// whatever the address they connect to.
+const tlsServerName = "goreman"
+
+// files of the TLS directory.
+const (
+	tlsCAFile     = "ca.pem"
+	tlsServerCert = "server.pem"
+	tlsServerKey  = "server-key.pem"
+	tlsClientCert = "client.pem"
+	tlsClientKey  = "client-key.pem"
+)
+
+// rpcTLSDir returns the directory of the certificates of the RPC server.
+func rpcTLSDir(cfg *config) (string, error) {
+	return rpcStatePath(cfg, ".tls")
+}
+
+// serverTLSConfig returns the TLS config of the TCP listener, which requires
+// client certificates issued by the local CA. The certificates are
+// generated on first use.
+func serverTLSConfig(cfg *config) (*tls.Config, error) {
+	dir, err := rpcTLSDir(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureCertificates(dir); err != nil {
+		return nil, err
+	}
+	pool, err := loadCA(dir)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, tlsServerCert), filepath.Join(dir, tlsServerKey))
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS13,
+	}, nil
+}
+
+// clientTLSConfig returns the TLS config of goreman run, with the client
+// certificate generated by goreman start.
+func clientTLSConfig(cfg *config) (*tls.Config, error) {
+	dir, err := rpcTLSDir(cfg)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := loadCA(dir)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, tlsClientCert), filepath.Join(dir, tlsClientKey))
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ServerName:   tlsServerName,
+		MinVersion:   tls.VersionTLS13,
+	}, nil
+}
+
+func loadCA(dir string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(filepath.Join(dir, tlsCAFile))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.New(filepath.Join(dir, tlsCAFile) + ": no certificate")
+	}
+	return pool, nil
+}
+
+// ensureCertificates generates a CA and a server and a client certificate
+// issued by it in dir, unless valid ones are there already.
+func ensureCertificates(dir string) error {
+	if certificatesValid(dir) {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	ca := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "goreman CA"},
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := issueCertificate(ca, ca, caKey, caKey, dir, tlsCAFile, "")
+	if err != nil {
+		return err
+	}
+	if ca, err = x509.ParseCertificate(caDER); err != nil {
+		return err
+	}
+	for _, c := range []struct {
+		cert, key string
+		template  *x509.Certificate
+	}{
+		{tlsServerCert, tlsServerKey, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: tlsServerName},
+			DNSNames:    []string{tlsServerName, "localhost"},
+			IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}},
+		{tlsClientCert, tlsClientKey, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "goreman client"},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}},
+	} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return err
+		}
+		c.template.KeyUsage = x509.KeyUsageDigitalSignature
+		if _, err := issueCertificate(c.template, ca, key, caKey, dir, c.cert, c.key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// issueCertificate signs template with the key of parent, valid for a year,
+// and writes it to certFile in dir, and key to keyFile unless it is empty.
+// It returns the certificate in DER.
+func issueCertificate(template, parent *x509.Certificate, key, parentKey *ecdsa.PrivateKey, dir, certFile, keyFile string) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().AddDate(1, 0, 0)
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(filepath.Join(dir, certFile), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil || keyFile == "" {
+		return der, err
+	}
+	b, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return der, os.WriteFile(filepath.Join(dir, keyFile), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0600)
+}
+
+// certificatesValid reports whether dir holds certificates which can be
+// used for at least another day.
+func certificatesValid(dir string) bool {
+	for _, files := range [][2]string{{tlsServerCert, tlsServerKey}, {tlsClientCert, tlsClientKey}} {
+		cert, err := tls.LoadX509KeyPair(filepath.Join(dir, files[0]), filepath.Join(dir, files[1]))
+		if err != nil || time.Now().AddDate(0, 0, 1).After(cert.Leaf.NotAfter) {
+			return false
+		}
+	}
+	_, err := loadCA(dir)
+	return err == nil
+}
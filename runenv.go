@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// command: run-env. parses the options in args and runs the command given
+// in args in the environment of the app, or of a proc with -p. It returns
+// the exit code of the command.
+func runEnvCommand(cfg *config, args []string) (int, error) {
+	fs := flag.NewFlagSet("run-env", flag.ExitOnError)
+	name := fs.String("p", "", "run in the environment and directory of this proc, including its PORT")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: goreman run-env [-p PROC] COMMAND [ARG...]\n\nOptions:\n")
+		fs.PrintDefaults()
+		os.Exit(0)
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+	}
+	return runEnv(cfg, *name, fs.Args())
+}
+
+// runEnv runs args with the environment of the proc name, or of the app if
+// name is empty. A single argument is run by the shell.
+func runEnv(cfg *config, name string, args []string) (int, error) {
+	environ, dir, err := procEnviron(cfg, name)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(args) == 1 {
+		args = append(cmdStart[:len(cmdStart):len(cmdStart)], args[0])
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = environ
+	cmd.Dir = dir
+
+	// forward signals to the command until it exits. The command is in the
+	// process group of goreman, so it gets the SIGINT of a Ctrl-C in the
+	// terminal itself.
+	sc := make(chan os.Signal, 10)
+	signal.Notify(sc, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(sc)
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-sc:
+				if sig == syscall.SIGINT && inForeground() {
+					continue
+				}
+				cmd.Process.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+	err = cmd.Wait()
+	close(done)
+	return exitCode(err)
+}
+
+// exitCode returns the exit code of a command from the error of Wait, like a
+// shell does for a command killed by a signal.
+func exitCode(err error) (int, error) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, err
+	}
+	if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal()), nil
+	}
+	return exitErr.ExitCode(), nil
+}
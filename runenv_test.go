@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunEnv(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"Procfile": "web: ./web\n",
+		".env":     "GOREMAN_TEST_FOO=global\n",
+		".env.ci":  "GOREMAN_TEST_FOO=ci\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newConfig := func() *config {
+		return &config{
+			Procfile: filepath.Join(dir, "Procfile"),
+			EnvFiles: []string{filepath.Join(dir, ".env")},
+			BasePort: 5000,
+			Procs: map[string]*procConfig{
+				"web": {Cwd: dir, Env: map[string]string{"GOREMAN_TEST_FOO": "web"}},
+			},
+		}
+	}
+
+	for _, tt := range []struct {
+		proc string
+		args []string
+		code int
+	}{
+		{"", []string{`test "$GOREMAN_TEST_FOO" = global && test -z "$PORT"`}, 0},
+		{"web", []string{`test "$GOREMAN_TEST_FOO" = web && test "$PORT" = 5000 && test -f Procfile`}, 0},
+		{"", []string{"sh", "-c", "exit 3"}, 3},
+	} {
+		code, err := runEnv(newConfig(), tt.proc, tt.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("%q: want exit code %d, got %d", tt.args, tt.code, code)
+		}
+	}
+
+	if _, err := runEnv(newConfig(), "db", []string{"true"}); err == nil {
+		t.Error("expected an error for an unknown proc")
+	}
+
+	// the env files of the profile are read for the app too.
+	cfg := newConfig()
+	cfg.Profile = "ci"
+	cfg.Profiles = map[string]*profile{"ci": {EnvFiles: []string{filepath.Join(dir, ".env.ci")}}}
+	code, err := runEnv(cfg, "", []string{`test "$GOREMAN_TEST_FOO" = ci`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 0 {
+		t.Errorf("profile: want exit code 0, got %d", code)
+	}
+
+	// the command exits with its own code when it is signaled.
+	pidFile := filepath.Join(dir, "pid")
+	go func() {
+		for {
+			time.Sleep(20 * time.Millisecond)
+			b, err := os.ReadFile(pidFile)
+			if pid, _ := strconv.Atoi(strings.TrimSpace(string(b))); err == nil && pid > 0 {
+				if p, err := os.FindProcess(pid); err == nil {
+					p.Signal(syscall.SIGTERM)
+				}
+				return
+			}
+		}
+	}()
+	code, err = runEnv(newConfig(), "", []string{"trap 'exit 7' TERM; echo $$ > " + pidFile + ".tmp; mv " + pidFile + ".tmp " + pidFile + "; while :; do sleep 0.01; done"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 7 {
+		t.Errorf("want exit code 7 after SIGTERM, got %d", code)
+	}
+}
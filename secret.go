@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// secretMask replaces secret values in output.
+const secretMask = "********"
+
+// secretRef returns the kind ("file" or "cmd") and the argument of a secret
+// reference such as "file:secrets/db" or "cmd:pass show db". A file URL such
+// as "file:///tmp/db" is not a reference.
+func secretRef(value string) (kind, arg string, ok bool) {
+	if arg, ok := strings.CutPrefix(value, "cmd:"); ok {
+		return "cmd", arg, true
+	}
+	if arg, ok := strings.CutPrefix(value, "file:"); ok && !strings.HasPrefix(arg, "//") {
+		return "file", arg, true
+	}
+	return "", "", false
+}
+
+// secret resolves a secret reference: the content of a file, or the output
+// of a command run by the shell, without the trailing newline. Each
+// reference is resolved only once.
+func (cfg *config) secret(kind, arg string) (string, error) {
+	key := kind + ":" + arg
+	if v, ok := cfg.secrets[key]; ok {
+		return v, nil
+	}
+	var b []byte
+	var err error
+	switch kind {
+	case "file":
+		b, err = os.ReadFile(arg)
+	case "cmd":
+		cs := append(cmdStart[:len(cmdStart):len(cmdStart)], arg)
+		cmd := exec.Command(cs[0], cs[1:]...)
+		cmd.Stdin = os.Stdin
+		cmd.Stderr = os.Stderr
+		b, err = cmd.Output()
+	}
+	if err != nil {
+		return "", err
+	}
+	v := string(bytes.TrimRight(b, "\r\n"))
+	if cfg.secrets == nil {
+		cfg.secrets = map[string]string{}
+	}
+	cfg.secrets[key] = v
+	return v, nil
+}
+
+// maskSecrets replaces the values of the secret variables of proc in env.
+func (proc *procInfo) maskSecrets(env map[string]string) {
+	for k := range proc.secrets {
+		if _, ok := env[k]; ok {
+			env[k] = secretMask
+		}
+	}
+}
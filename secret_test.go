@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestSecrets(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"Procfile":  "web: ./web\nworker: ./worker\n",
+		"db.secret": "s3cret\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count := filepath.Join(dir, "count")
+	env := map[string]string{
+		"DB_PASSWORD": "file:" + filepath.Join(dir, "db.secret"),
+		"API_TOKEN":   "cmd:echo run >> " + count + "; echo t0ken",
+		"CACHE_URL":   "file:///tmp/cache",
+	}
+	newConfig := func() *config {
+		return &config{
+			Procfile: filepath.Join(dir, "Procfile"),
+			BasePort: 5000,
+			Procs: map[string]*procConfig{
+				"web":    {Env: env},
+				"worker": {Env: env},
+			},
+		}
+	}
+
+	cfg := newConfig()
+	if err := loadProcs(cfg); err != nil {
+		t.Fatal(err)
+	}
+	web := findProc("web")
+	for k, v := range map[string]string{"DB_PASSWORD": "s3cret", "API_TOKEN": "t0ken", "CACHE_URL": "file:///tmp/cache"} {
+		if web.env[k] != v {
+			t.Errorf("%s: want %q, got %q", k, v, web.env[k])
+		}
+	}
+	// the command is run once for both procs.
+	if b, err := os.ReadFile(count); err != nil || string(b) != "run\n" {
+		t.Errorf("command was not run once: %q, %v", b, err)
+	}
+
+	out := captureStdout(t, func() error { return envCommand(newConfig(), []string{"-diff", "web"}) })
+	if !strings.Contains(out, `DB_PASSWORD="`+secretMask+`"`) || strings.Contains(out, "t0ken") || !strings.Contains(out, "file:///tmp/cache") {
+		t.Errorf("secrets are not masked:\n%s", out)
+	}
+	out = captureStdout(t, func() error { return envCommand(newConfig(), []string{"-diff", "-secrets", "web"}) })
+	if !strings.Contains(out, `DB_PASSWORD="s3cret"`) {
+		t.Errorf("secrets are masked with -secrets:\n%s", out)
+	}
+
+	cfg = newConfig()
+	cfg.Export.DryRun = true
+	out = captureStdout(t, func() error { return export(cfg, "upstart", filepath.Join(dir, "out")) })
+	if !strings.Contains(out, `env DB_PASSWORD="`+secretMask+`"`) || strings.Contains(out, "s3cret") {
+		t.Errorf("secrets are not masked in the export:\n%s", out)
+	}
+
+	cfg = newConfig()
+	cfg.Procs["web"] = &procConfig{Env: map[string]string{"DB_PASSWORD": "file:" + filepath.Join(dir, "missing")}}
+	if err := loadProcs(cfg); err == nil || !strings.Contains(err.Error(), "web: DB_PASSWORD: open ") {
+		t.Errorf("want an error for a missing secret file, got %v", err)
+	}
+}